@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gh-efforts/lotus-pilot/pilot"
+	"github.com/urfave/cli/v2"
+)
+
+var autopilotCmd = &cli.Command{
+	Name:  "autopilot",
+	Usage: "manage autopilot",
+	Subcommands: []*cli.Command{
+		autopilotReportCmd,
+	},
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "connect",
+			Value: "127.0.0.1:6788",
+		},
+	},
+}
+
+var autopilotReportCmd = &cli.Command{
+	Name:  "report",
+	Usage: "show the policy, evidence and proposals of the last autopilot round",
+	Action: func(cctx *cli.Context) error {
+		url := fmt.Sprintf("http://%s/autopilot/report", cctx.String("connect"))
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		var report pilot.AutopilotReport
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		if err != nil {
+			return err
+		}
+
+		out, err := json.MarshalIndent(&report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	},
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gh-efforts/lotus-pilot/pilot"
+	"github.com/urfave/cli/v2"
+)
+
+var hostCmd = &cli.Command{
+	Name:  "host",
+	Usage: "manage host",
+	Subcommands: []*cli.Command{
+		hostListUnavailableCmd,
+		hostAvailableCmd,
+	},
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "connect",
+			Value: "127.0.0.1:6788",
+		},
+	},
+}
+
+var hostListUnavailableCmd = &cli.Command{
+	Name:  "list-unavailable",
+	Usage: "list hosts marked unavailable by drain",
+	Action: func(cctx *cli.Context) error {
+		url := fmt.Sprintf("http://%s/host/unavailable", cctx.String("connect"))
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		var hosts []pilot.UnavailableHost
+		err = json.NewDecoder(resp.Body).Decode(&hosts)
+		if err != nil {
+			return err
+		}
+
+		for _, h := range hosts {
+			fmt.Printf("%s\t%s\t%s\n", h.Hostname, h.SwitchID, h.Time.Format("2006-01-02 15:04:05"))
+		}
+		return nil
+	},
+}
+
+var hostAvailableCmd = &cli.Command{
+	Name:      "available",
+	Usage:     "mark host available again",
+	ArgsUsage: "[hostname]",
+	Action: func(cctx *cli.Context) error {
+		url := fmt.Sprintf("http://%s/host/available/%s", cctx.String("connect"), cctx.Args().First())
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+		return nil
+	},
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gh-efforts/lotus-pilot/repo"
+	"github.com/urfave/cli/v2"
+)
+
+var keystoreCmd = &cli.Command{
+	Name:  "keystore",
+	Usage: "manage encrypted miner tokens in repo, password from env " + repo.KeystorePassEnv,
+	Subcommands: []*cli.Command{
+		keystorePutCmd,
+		keystoreListCmd,
+	},
+}
+
+var keystorePutCmd = &cli.Command{
+	Name:      "put",
+	Usage:     "read token from stdin and save it encrypted, use keystore:<name> as miner token in config",
+	ArgsUsage: "[name]",
+	Action: func(cctx *cli.Context) error {
+		name := cctx.Args().First()
+		if name == "" {
+			return errors.New("name is empty")
+		}
+
+		r, err := repo.New(cctx.String("repo"))
+		if err != nil {
+			return err
+		}
+
+		token, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && token == "" {
+			return err
+		}
+
+		err = r.PutKey(name, strings.TrimSpace(token))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("saved keystore:%s\n", name)
+		return nil
+	},
+}
+
+var keystoreListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "list names in keystore",
+	Action: func(cctx *cli.Context) error {
+		r, err := repo.New(cctx.String("repo"))
+		if err != nil {
+			return err
+		}
+
+		names, err := r.ListKeys()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Printf("keystore:%s\n", name)
+		}
+		return nil
+	},
+}
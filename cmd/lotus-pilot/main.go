@@ -34,6 +34,11 @@ func main() {
 		minerCmd,
 		switchCmd,
 		scriptCmd,
+		hostCmd,
+		workerCmd,
+		keystoreCmd,
+		autopilotCmd,
+		simulateCmd,
 		pprofCmd,
 	}
 
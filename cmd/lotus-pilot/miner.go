@@ -8,6 +8,9 @@ import (
 	"io"
 	"net/http"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/gh-efforts/lotus-pilot/pilot"
@@ -24,6 +27,11 @@ var minerCmd = &cli.Command{
 		minerRemoveCmd,
 		minerListCmd,
 		minerWorkerCmd,
+		minerHealthCmd,
+		minerMaintenanceCmd,
+		minerDurationsCmd,
+		minerSnapshotsCmd,
+		minerConnsCmd,
 	},
 	Flags: []cli.Flag{
 		&cli.StringFlag{
@@ -46,18 +54,39 @@ var minerAddCmd = &cli.Command{
 		&cli.StringFlag{
 			Name: "token",
 		},
+		&cli.StringFlag{
+			Name:  "api-info",
+			Usage: "lotus api info, TOKEN:/ip4/127.0.0.1/tcp/2345/http or TOKEN:https://miner.example.com, overrides addr and token",
+		},
+		&cli.StringFlag{
+			Name:  "ca-file",
+			Usage: "ca certificate to verify the miner api when using https",
+		},
+		&cli.IntFlag{
+			Name:  "min-workers",
+			Usage: "minimum number of workers the miner keeps",
+		},
+		&cli.IntFlag{
+			Name:  "max-workers",
+			Usage: "maximum number of workers the miner has, 0 means no limit",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		id := cctx.String("miner-id")
 		addr := cctx.String("addr")
 		token := cctx.String("token")
-		if id == "" || addr == "" || token == "" {
+		info := cctx.String("api-info")
+		if id == "" || (info == "" && (addr == "" || token == "")) {
 			return errors.New("param is empty")
 		}
 
 		api := config.APIInfo{
-			Addr:  addr,
-			Token: token,
+			Addr:       addr,
+			Token:      token,
+			Info:       info,
+			CAFile:     cctx.String("ca-file"),
+			MinWorkers: cctx.Int("min-workers"),
+			MaxWorkers: cctx.Int("max-workers"),
 		}
 		miner := pilot.MinerAPI{
 			Miner: id,
@@ -181,6 +210,217 @@ var minerWorkerCmd = &cli.Command{
 	},
 }
 
+var minerHealthCmd = &cli.Command{
+	Name:  "health",
+	Usage: "list miner health",
+	Action: func(cctx *cli.Context) error {
+		url := fmt.Sprintf("http://%s/miner/health", cctx.String("connect"))
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		var health map[string]pilot.MinerHealth
+		err = json.NewDecoder(resp.Body).Decode(&health)
+		if err != nil {
+			return err
+		}
+
+		for miner, h := range health {
+			fmt.Printf("Miner: %s\n", miner)
+			fmt.Printf("Healthy: %t\n", h.Healthy())
+			fmt.Printf("Maintenance: %t\n", h.Maintenance)
+			fmt.Printf("Failures: %d\n", h.Failures)
+			fmt.Printf("LastErr: %s\n", h.LastErr)
+			fmt.Printf("LastOK: %s\n", h.LastOK.Format("2006-01-02 15:04:05"))
+			if !h.Healthy() {
+				fmt.Printf("UnhealthySince: %s\n", h.UnhealthySince.Format("2006-01-02 15:04:05"))
+			}
+			fmt.Printf("Workers: %d\n", len(h.Workers))
+			if h.Evacuation != uuid.Nil {
+				fmt.Printf("Evacuation: %s\n", h.Evacuation)
+			}
+			fmt.Println()
+		}
+		return nil
+	},
+}
+
+var minerDurationsCmd = &cli.Command{
+	Name:  "durations",
+	Usage: "list task durations learned from worker jobs",
+	Action: func(cctx *cli.Context) error {
+		url := fmt.Sprintf("http://%s/miner/durations", cctx.String("connect"))
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		var durations map[string]pilot.TaskDuration
+		err = json.NewDecoder(resp.Body).Decode(&durations)
+		if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(durations))
+		for k := range durations {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			d := durations[k]
+			fmt.Printf("%s avg: %s samples: %d\n", k, time.Duration(d.Avg).Round(time.Second), d.Samples)
+		}
+		return nil
+	},
+}
+
+var minerSnapshotsCmd = &cli.Command{
+	Name:  "snapshots",
+	Usage: "list time and age of miner worker snapshots",
+	Action: func(cctx *cli.Context) error {
+		url := fmt.Sprintf("http://%s/miner/snapshots", cctx.String("connect"))
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		var status []pilot.SnapshotStatus
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range status {
+			fmt.Printf("Miner: %s\n", s.Miner)
+			if !s.Time.IsZero() {
+				fmt.Printf("Time: %s\n", s.Time.Format("2006-01-02 15:04:05"))
+				fmt.Printf("Age: %s\n", (time.Duration(s.Age) * time.Second).String())
+				fmt.Printf("Workers: %d\n", s.Workers)
+			}
+			if s.LastErr != "" {
+				fmt.Printf("LastErr: %s\n", s.LastErr)
+			}
+			fmt.Println()
+		}
+		return nil
+	},
+}
+
+var minerConnsCmd = &cli.Command{
+	Name:  "conns",
+	Usage: "list miner api connection state, latency and last error",
+	Action: func(cctx *cli.Context) error {
+		url := fmt.Sprintf("http://%s/miner/conns", cctx.String("connect"))
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		var status []pilot.ConnStatus
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range status {
+			fmt.Printf("Miner: %s\n", s.Miner)
+			fmt.Printf("Addr: %s\n", s.Addr)
+			fmt.Printf("State: %s\n", s.State)
+			if s.Version != "" {
+				fmt.Printf("Version: %s\n", s.Version)
+			}
+			fmt.Printf("Latency: %.2fms\n", s.Latency)
+			if !s.LastOK.IsZero() {
+				fmt.Printf("LastOK: %s\n", s.LastOK.Format("2006-01-02 15:04:05"))
+			}
+			fmt.Printf("Reconnects: %d\n", s.Reconnects)
+			if s.LastErr != "" {
+				fmt.Printf("LastErr: %s\n", s.LastErr)
+			}
+			if !s.NextRetry.IsZero() {
+				fmt.Printf("NextRetry: %s\n", s.NextRetry.Format("2006-01-02 15:04:05"))
+			}
+			fmt.Println()
+		}
+		return nil
+	},
+}
+
+var minerMaintenanceCmd = &cli.Command{
+	Name:      "maintenance",
+	Usage:     "mark miner in maintenance, workers will be evacuated to fallback miner after grace",
+	ArgsUsage: "[minerID]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "exit",
+			Usage: "exit maintenance",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		maddr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		action := "enter"
+		if cctx.Bool("exit") {
+			action = "exit"
+		}
+		url := fmt.Sprintf("http://%s/miner/maintenance/%s/%s", cctx.String("connect"), action, maddr.String())
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+		return nil
+	},
+}
+
 func printWorkerInfo(wi map[uuid.UUID]pilot.WorkerInfo) {
 	for _, w := range wi {
 		fmt.Printf("WorkerID: %s\n", w.WorkerID)
@@ -193,6 +433,25 @@ func printWorkerInfo(wi map[uuid.UUID]pilot.WorkerInfo) {
 		fmt.Printf("LastStart: %s\n", w.LastStart)
 		fmt.Printf("Sectors: %s\n", reflect.ValueOf(w.Sectors).MapKeys())
 		fmt.Printf("Tasks: %s\n", reflect.ValueOf(w.Tasks).MapKeys())
+		fmt.Printf("Roles: %s\n", w.Roles)
+		fmt.Printf("Labels: %s\n", formatLabels(w.Labels))
+		fmt.Printf("Resources: mem %dGiB cpus %d gpus %d\n", w.Resources.MemPhysical>>30, w.Resources.CPUs, len(w.Resources.GPUs))
+		for _, path := range w.Paths {
+			if path.Err != "" {
+				fmt.Printf("SealPath: %s sectors: %d err: %s\n", path.ID, len(path.Sectors), path.Err)
+				continue
+			}
+			fmt.Printf("SealPath: %s sectors: %d capacity: %dGiB available: %dGiB\n", path.ID, len(path.Sectors), path.Capacity>>30, path.Available>>30)
+		}
 		fmt.Println()
 	}
 }
+
+func formatLabels(labels map[string]string) string {
+	var kvs []string
+	for k, v := range labels {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
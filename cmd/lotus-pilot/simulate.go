@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/gh-efforts/lotus-pilot/pilot"
+	"github.com/gh-efforts/lotus-pilot/repo"
+	"github.com/urfave/cli/v2"
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+var simulateCmd = &cli.Command{
+	Name:  "simulate",
+	Usage: "replay recorded snapshots through worker picking and switching policies",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "replay records since this time, eg: '2024-05-01 00:00:00'",
+		},
+		&cli.StringFlag{
+			Name:  "until",
+			Usage: "replay records until this time",
+		},
+		&cli.BoolFlag{
+			Name:  "autopilot",
+			Usage: "simulate autopilot configured in config, dryRun is ignored",
+		},
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "switch request submitted at the start of the simulation",
+		},
+		&cli.StringFlag{
+			Name: "to",
+		},
+		&cli.IntFlag{
+			Name: "count",
+		},
+		&cli.StringFlag{
+			Name:  "role",
+			Value: "sealing",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print report as json",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		r, err := repo.New(cctx.String("repo"))
+		if err != nil {
+			return err
+		}
+
+		opts := pilot.SimulateOptions{
+			Autopilot: cctx.Bool("autopilot"),
+		}
+		if s := cctx.String("since"); s != "" {
+			opts.Since, err = time.ParseInLocation(timeLayout, s, time.Local)
+			if err != nil {
+				return err
+			}
+		}
+		if s := cctx.String("until"); s != "" {
+			opts.Until, err = time.ParseInLocation(timeLayout, s, time.Local)
+			if err != nil {
+				return err
+			}
+		}
+		if cctx.String("to") != "" {
+			to, err := address.NewFromString(cctx.String("to"))
+			if err != nil {
+				return err
+			}
+			from, err := address.NewFromString(cctx.String("from"))
+			if err != nil {
+				return err
+			}
+			opts.Requests = append(opts.Requests, pilot.SwitchRequest{
+				From:  from,
+				To:    to,
+				Count: cctx.Int("count"),
+				Role:  pilot.WorkerRole(cctx.String("role")),
+			})
+		}
+		if !opts.Autopilot && len(opts.Requests) == 0 {
+			return fmt.Errorf("need --autopilot or --to")
+		}
+
+		report, err := pilot.Simulate(cctx.Context, r, opts)
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			out, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+
+		fmt.Printf("replay: %s - %s snapshots: %d\n", report.Start.Format(timeLayout), report.End.Format(timeLayout), report.Snapshots)
+		fmt.Printf("moves: %d\n\n", len(report.Moves))
+		for _, m := range report.Moves {
+			fmt.Printf("%s %s %s -> %s %s\n", m.SwitchID, m.Hostname, m.From, m.To, m.State)
+			fmt.Printf("  type: %s source: %s\n", m.Type, m.Source)
+			fmt.Printf("  picked: %s started: %s stopped: %s idle: %s\n",
+				m.Picked.Format(timeLayout), formatTime(m.Started), formatTime(m.Stopped), time.Duration(m.Idle))
+		}
+		for _, e := range report.Errors {
+			fmt.Printf("error: %s\n", e)
+		}
+		return nil
+	},
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(timeLayout)
+}
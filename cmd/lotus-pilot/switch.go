@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
 	"github.com/gh-efforts/lotus-pilot/pilot"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
 	"github.com/google/uuid"
 	"github.com/urfave/cli/v2"
 )
@@ -18,6 +21,8 @@ var switchCmd = &cli.Command{
 	Usage: "manage switch",
 	Subcommands: []*cli.Command{
 		switchNewCmd,
+		switchDrainCmd,
+		switchAttachCmd,
 		switchGetCmd,
 		switchCancelCmd,
 		switchRemoveCmd,
@@ -37,7 +42,11 @@ var switchNewCmd = &cli.Command{
 	Usage: "send new switch",
 	Flags: []cli.Flag{
 		&cli.StringFlag{
-			Name: "from",
+			Name:  "from",
+			Usage: "if empty, pick worker from from-list or all miners except to (need count or min-remain)",
+		},
+		&cli.StringSliceFlag{
+			Name: "from-list",
 		},
 		&cli.StringFlag{
 			Name: "to",
@@ -46,21 +55,212 @@ var switchNewCmd = &cli.Command{
 			Name: "count",
 		},
 		&cli.BoolFlag{
-			Name: "disableAP",
+			Name:  "disableAP",
+			Usage: "same as --disable-task AP",
+		},
+		&cli.StringSliceFlag{
+			Name:  "disable-task",
+			Usage: "task types to disable before switching, eg: AP, PC1, RU, PR2",
 		},
 		&cli.StringSliceFlag{
 			Name: "worker",
 		},
+		&cli.IntFlag{
+			Name:  "min-remain",
+			Usage: "minimum number of workers each from miner keeps",
+		},
+		&cli.StringFlag{
+			Name:  "role",
+			Usage: "worker role to pick: sealing, snap, commit",
+			Value: "sealing",
+		},
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "idempotency key, resending the same request with the same key returns the existing switch",
+		},
+		&cli.BoolFlag{
+			Name:  "override",
+			Usage: "ignore minWorkers and maxWorkers of miners, only for emergencies",
+		},
+		&cli.StringFlag{
+			Name:  "strategy",
+			Usage: "worker pick strategy: default, least-sectors, match, newest-hardware, random, eta",
+		},
+		&cli.StringFlag{
+			Name:  "strategy-arg",
+			Usage: "hostname pattern or label selector for match strategy, eg: *-L06-* or rack=L06",
+		},
+		&cli.StringFlag{
+			Name:  "selector",
+			Usage: "only pick workers whose host labels match, eg: rack=L06,gpu=3090",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "allow switching pinned workers listed by --worker",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only show picked workers and their scores, do not create switch",
+		},
+		&cli.StringSliceFlag{
+			Name:  "switch-task",
+			Usage: "task types that must finish before starting on to, default: AP PC1 PC2",
+		},
+		&cli.StringSliceFlag{
+			Name:  "stop-task",
+			Usage: "task types that must finish before stopping, default: all tasks",
+		},
+		&cli.BoolFlag{
+			Name:  "stop-ignore-sector",
+			Usage: "stop worker even if it still has sectors",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		from, err := address.NewFromString(cctx.String("from"))
 		if err != nil {
 			return err
 		}
+		fromList := []address.Address{}
+		for _, f := range cctx.StringSlice("from-list") {
+			a, err := address.NewFromString(f)
+			if err != nil {
+				return err
+			}
+			fromList = append(fromList, a)
+		}
 		to, err := address.NewFromString(cctx.String("to"))
 		if err != nil {
 			return err
 		}
+		disableTasks, err := parseDisableTasks(cctx)
+		if err != nil {
+			return err
+		}
+		worker := []uuid.UUID{}
+		for _, w := range cctx.StringSlice("worker") {
+			i, err := uuid.Parse(w)
+			if err != nil {
+				return err
+			}
+			worker = append(worker, i)
+		}
+
+		req := pilot.SwitchRequest{
+			From:         from,
+			FromList:     fromList,
+			To:           to,
+			Count:        cctx.Int("count"),
+			Worker:       worker,
+			DisableTasks: disableTasks,
+			MinRemain:    cctx.Int("min-remain"),
+			Key:          cctx.String("key"),
+			Policy:       parsePolicy(cctx),
+			Role:         pilot.WorkerRole(cctx.String("role")),
+			Override:     cctx.Bool("override"),
+			Strategy:     cctx.String("strategy"),
+			StrategyArg:  cctx.String("strategy-arg"),
+			Selector:     cctx.String("selector"),
+			Force:        cctx.Bool("force"),
+		}
+
+		body, err := json.Marshal(&req)
+		if err != nil {
+			return err
+		}
+
+		url := fmt.Sprintf("http://%s/switch/new", cctx.String("connect"))
+		if cctx.Bool("dry-run") {
+			url = fmt.Sprintf("http://%s/switch/pick", cctx.String("connect"))
+		}
+		resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		var ss pilot.SwitchState
+		err = json.NewDecoder(resp.Body).Decode(&ss)
+		if err != nil {
+			return err
+		}
+
+		printSwitchState(ss)
+		return nil
+	},
+}
+
+var switchDrainCmd = &cli.Command{
+	Name:  "drain",
+	Usage: "stop workers without starting them on another miner, the hosts are marked unavailable",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name: "from",
+		},
+		&cli.IntFlag{
+			Name: "count",
+		},
+		&cli.BoolFlag{
+			Name:  "disableAP",
+			Usage: "same as --disable-task AP",
+		},
+		&cli.StringSliceFlag{
+			Name:  "disable-task",
+			Usage: "task types to disable before switching, eg: AP, PC1, RU, PR2",
+		},
+		&cli.StringSliceFlag{
+			Name: "worker",
+		},
+		&cli.StringFlag{
+			Name:  "role",
+			Usage: "worker role to pick: sealing, snap, commit",
+			Value: "sealing",
+		},
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "idempotency key, resending the same request with the same key returns the existing switch",
+		},
+		&cli.BoolFlag{
+			Name:  "override",
+			Usage: "ignore minWorkers and maxWorkers of miners, only for emergencies",
+		},
+		&cli.StringFlag{
+			Name:  "strategy",
+			Usage: "worker pick strategy: default, least-sectors, match, newest-hardware, random, eta",
+		},
+		&cli.StringFlag{
+			Name:  "strategy-arg",
+			Usage: "hostname pattern or label selector for match strategy, eg: *-L06-* or rack=L06",
+		},
+		&cli.StringFlag{
+			Name:  "selector",
+			Usage: "only pick workers whose host labels match, eg: rack=L06,gpu=3090",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "allow switching pinned workers listed by --worker",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only show picked workers and their scores, do not create switch",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		from, err := address.NewFromString(cctx.String("from"))
+		if err != nil {
+			return err
+		}
+		disableTasks, err := parseDisableTasks(cctx)
+		if err != nil {
+			return err
+		}
 		worker := []uuid.UUID{}
 		for _, w := range cctx.StringSlice("worker") {
 			i, err := uuid.Parse(w)
@@ -71,11 +271,80 @@ var switchNewCmd = &cli.Command{
 		}
 
 		req := pilot.SwitchRequest{
-			From:      from,
-			To:        to,
-			Count:     cctx.Int("count"),
-			Worker:    worker,
-			DisableAP: cctx.Bool("disableAP"),
+			Type:         pilot.SwitchTypeDrain,
+			Role:         pilot.WorkerRole(cctx.String("role")),
+			From:         from,
+			Count:        cctx.Int("count"),
+			Worker:       worker,
+			DisableTasks: disableTasks,
+			Key:          cctx.String("key"),
+			Override:     cctx.Bool("override"),
+			Strategy:     cctx.String("strategy"),
+			StrategyArg:  cctx.String("strategy-arg"),
+			Selector:     cctx.String("selector"),
+			Force:        cctx.Bool("force"),
+		}
+
+		body, err := json.Marshal(&req)
+		if err != nil {
+			return err
+		}
+
+		url := fmt.Sprintf("http://%s/switch/new", cctx.String("connect"))
+		if cctx.Bool("dry-run") {
+			url = fmt.Sprintf("http://%s/switch/pick", cctx.String("connect"))
+		}
+		resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		var ss pilot.SwitchState
+		err = json.NewDecoder(resp.Body).Decode(&ss)
+		if err != nil {
+			return err
+		}
+
+		printSwitchState(ss)
+		return nil
+	},
+}
+
+var switchAttachCmd = &cli.Command{
+	Name:  "attach",
+	Usage: "start workers for miner on fresh hosts",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name: "to",
+		},
+		&cli.StringSliceFlag{
+			Name: "hostname",
+		},
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "idempotency key, resending the same request with the same key returns the existing switch",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		to, err := address.NewFromString(cctx.String("to"))
+		if err != nil {
+			return err
+		}
+
+		req := pilot.SwitchRequest{
+			Type:     pilot.SwitchTypeAttach,
+			To:       to,
+			Hostname: cctx.StringSlice("hostname"),
+			Key:      cctx.String("key"),
 		}
 
 		body, err := json.Marshal(&req)
@@ -268,17 +537,59 @@ var switchResumeCmd = &cli.Command{
 	},
 }
 
+// parsePolicy 没有设置任何策略参数时返回nil，使用miner配置的策略
+func parsePolicy(cctx *cli.Context) *config.Policy {
+	if !cctx.IsSet("switch-task") && !cctx.IsSet("stop-task") && !cctx.IsSet("stop-ignore-sector") {
+		return nil
+	}
+
+	pol := config.DefaultPolicy()
+	if cctx.IsSet("switch-task") {
+		pol.SwitchTasks = cctx.StringSlice("switch-task")
+	}
+	if cctx.IsSet("stop-task") {
+		pol.StopTasks = cctx.StringSlice("stop-task")
+		pol.StopAllTasks = false
+	}
+	pol.StopNoSector = !cctx.Bool("stop-ignore-sector")
+
+	return &pol
+}
+
+func parseDisableTasks(cctx *cli.Context) ([]sealtasks.TaskType, error) {
+	var out []sealtasks.TaskType
+	if cctx.Bool("disableAP") {
+		out = append(out, sealtasks.TTAddPiece)
+	}
+	for _, t := range cctx.StringSlice("disable-task") {
+		tt, err := pilot.ParseTaskType(t)
+		if err != nil {
+			return nil, err
+		}
+		if tt == sealtasks.TTAddPiece && cctx.Bool("disableAP") {
+			continue
+		}
+		out = append(out, tt)
+	}
+	return out, nil
+}
+
 func printSwitchState(ss pilot.SwitchState) {
 	fmt.Printf("switchID: %s\n", ss.ID)
 	fmt.Printf("state: %s\n", ss.State)
 	if ss.ErrMsg != "" {
 		fmt.Printf("errMsg: %s\n", ss.ErrMsg)
 	}
-	fmt.Printf("switch request %+v\n\n", ss.Req)
+	fmt.Printf("switch request %+v\n", ss.Req)
+	if ss.Progress != nil {
+		fmt.Printf("progress: %.1f%% eta: %s remaining: %s known: %t\n", ss.Progress.Percent, ss.Progress.ETA.Format("2006-01-02 15:04:05"), time.Duration(ss.Progress.Remaining).Round(time.Second), ss.Progress.Known)
+	}
+	fmt.Println()
 
 	for _, w := range ss.Worker {
 		fmt.Printf("workerID: %s\n", w.WorkerID)
 		fmt.Printf("hostname: %s\n", w.Hostname)
+		fmt.Printf("from: %s\n", w.From)
 		fmt.Printf("state: %s\n", w.State)
 		if w.State != pilot.StateWorkerPicked {
 			fmt.Printf("resume: %s\n", w.Resume)
@@ -286,8 +597,22 @@ func printSwitchState(ss pilot.SwitchState) {
 		if w.ErrMsg != "" {
 			fmt.Printf("errMsg: %s\n", w.ErrMsg)
 		}
+		if w.ETA != nil {
+			fmt.Printf("eta: switch %s stop %s known: %t\n", time.Duration(w.ETA.Switch).Round(time.Second), time.Duration(w.ETA.Stop).Round(time.Second), w.ETA.Known)
+		}
 		if w.Try != 0 {
 			fmt.Printf("try: %d\n\n", w.Try)
 		}
 	}
+
+	if len(ss.Order) != 0 {
+		fmt.Printf("\npick order, keys: %v\n", ss.Order[0].Keys)
+		for i, o := range ss.Order {
+			picked := "picked"
+			if !o.Picked {
+				picked = "skip: " + o.Skip
+			}
+			fmt.Printf("%d. %s %s %v %s\n", i+1, o.Hostname, o.From, o.Values, picked)
+		}
+	}
 }
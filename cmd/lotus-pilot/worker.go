@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gh-efforts/lotus-pilot/pilot"
+	"github.com/urfave/cli/v2"
+)
+
+var workerCmd = &cli.Command{
+	Name:  "worker",
+	Usage: "manage pinned workers",
+	Subcommands: []*cli.Command{
+		workerPinCmd,
+		workerUnpinCmd,
+		workerListPinnedCmd,
+	},
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "connect",
+			Value: "127.0.0.1:6788",
+		},
+	},
+}
+
+var workerPinCmd = &cli.Command{
+	Name:      "pin",
+	Usage:     "pin workers so pilot never picks them automatically",
+	ArgsUsage: "[hostname|workerID|label selector]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name: "reason",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		pw := pilot.PinnedWorker{
+			Target: cctx.Args().First(),
+			Reason: cctx.String("reason"),
+		}
+		return postPin(cctx, "pin", pw)
+	},
+}
+
+var workerUnpinCmd = &cli.Command{
+	Name:      "unpin",
+	Usage:     "remove pinned target",
+	ArgsUsage: "[hostname|workerID|label selector]",
+	Action: func(cctx *cli.Context) error {
+		pw := pilot.PinnedWorker{
+			Target: cctx.Args().First(),
+		}
+		return postPin(cctx, "unpin", pw)
+	},
+}
+
+var workerListPinnedCmd = &cli.Command{
+	Name:  "list-pinned",
+	Usage: "list pinned targets",
+	Action: func(cctx *cli.Context) error {
+		url := fmt.Sprintf("http://%s/worker/pinned", cctx.String("connect"))
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		var pinned []pilot.PinnedWorker
+		err = json.NewDecoder(resp.Body).Decode(&pinned)
+		if err != nil {
+			return err
+		}
+
+		for _, pw := range pinned {
+			fmt.Printf("%s\t%s\t%s\n", pw.Target, pw.Time.Format("2006-01-02 15:04:05"), pw.Reason)
+		}
+		return nil
+	},
+}
+
+func postPin(cctx *cli.Context, action string, pw pilot.PinnedWorker) error {
+	body, err := json.Marshal(&pw)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://%s/worker/%s", cctx.String("connect"), action)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		r, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+	}
+	return nil
+}
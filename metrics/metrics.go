@@ -18,12 +18,17 @@ var (
 	Commit, _  = tag.NewKey("commit")
 
 	Endpoint, _ = tag.NewKey("endpoint")
+	Miner, _    = tag.NewKey("miner")
 )
 
 // Measures
 var (
 	Info               = stats.Int64("info", "Arbitrary counter to tag pilot info to", stats.UnitDimensionless)
 	APIRequestDuration = stats.Float64("api/request_duration_ms", "Duration of API requests", stats.UnitMilliseconds)
+	SnapshotAge        = stats.Float64("miner/snapshot_age_seconds", "Age of the miner worker snapshot", stats.UnitSeconds)
+	MinerWorkers       = stats.Int64("miner/workers", "Number of workers in the miner snapshot", stats.UnitDimensionless)
+	MinerConnected     = stats.Int64("miner/connected", "Whether the miner api is connected", stats.UnitDimensionless)
+	MinerProbeLatency  = stats.Float64("miner/probe_latency_ms", "Latency of the miner api probe", stats.UnitMilliseconds)
 )
 
 // Views
@@ -40,11 +45,35 @@ var (
 		Aggregation: defaultMillisecondsDistribution,
 		TagKeys:     []tag.Key{Endpoint},
 	}
+	SnapshotAgeView = &view.View{
+		Measure:     SnapshotAge,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{Miner},
+	}
+	MinerWorkersView = &view.View{
+		Measure:     MinerWorkers,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{Miner},
+	}
+	MinerConnectedView = &view.View{
+		Measure:     MinerConnected,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{Miner},
+	}
+	MinerProbeLatencyView = &view.View{
+		Measure:     MinerProbeLatency,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{Miner},
+	}
 )
 
 var Views = []*view.View{
 	InfoView,
 	APIRequestDurationView,
+	SnapshotAgeView,
+	MinerWorkersView,
+	MinerConnectedView,
+	MinerProbeLatencyView,
 }
 
 // SinceInMilliseconds returns the duration of time since the provide time as a float64.
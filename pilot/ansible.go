@@ -4,20 +4,78 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/apenella/go-ansible/pkg/adhoc"
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
 	"github.com/gh-efforts/lotus-pilot/build"
+	"github.com/gh-efforts/lotus-pilot/repo"
 )
 
 const RunCmdTimeout = time.Second * 30
 
-func disableAPCmd(ctx context.Context, hostname, miner string) error {
+// executor 在worker机器上执行命令，simulate使用不执行命令的executor
+type executor interface {
+	disableTasks(ctx context.Context, hostname, miner string, tasks []sealtasks.TaskType) error
+	enableTasks(ctx context.Context, hostname, miner string, tasks []sealtasks.TaskType) error
+	workerRun(ctx context.Context, hostname, to, scriptsPath string) error
+	workerStop(ctx context.Context, hostname, from string) error
+}
+
+type ansibleExecutor struct {
+	//不为空时运行脚本前把miner API信息复制到worker机器的这个目录
+	apiInfoDir string
+}
+
+func (ansibleExecutor) disableTasks(ctx context.Context, hostname, miner string, tasks []sealtasks.TaskType) error {
+	return disableTasksCmd(ctx, hostname, miner, tasks)
+}
+
+func (ansibleExecutor) enableTasks(ctx context.Context, hostname, miner string, tasks []sealtasks.TaskType) error {
+	return enableTasksCmd(ctx, hostname, miner, tasks)
+}
+
+func (e ansibleExecutor) workerRun(ctx context.Context, hostname, to, scriptsPath string) error {
+	if e.apiInfoDir != "" {
+		err := copyAPIInfoCmd(ctx, hostname, to, scriptsPath, e.apiInfoDir)
+		if err != nil {
+			return err
+		}
+	}
+	return workerRunCmd(ctx, hostname, to, scriptsPath)
+}
+
+func (ansibleExecutor) workerStop(ctx context.Context, hostname, from string) error {
+	return workerStopCmd(ctx, hostname, from)
+}
+
+func disableTasksCmd(ctx context.Context, hostname, miner string, tasks []sealtasks.TaskType) error {
+	return workerTasksCmd(ctx, hostname, miner, "disable", tasks)
+}
+
+func enableTasksCmd(ctx context.Context, hostname, miner string, tasks []sealtasks.TaskType) error {
+	return workerTasksCmd(ctx, hostname, miner, "enable", tasks)
+}
+
+func workerTasksCmd(ctx context.Context, hostname, miner, op string, tasks []sealtasks.TaskType) error {
 	if build.SkipAnsible {
-		log.Debug("disableAPCmd test")
+		log.Debugf("%sTasksCmd test", op)
 		return nil
 	}
-	arg := fmt.Sprintf("lotus-worker --worker-repo=%s tasks disable AP", workerRepo(miner))
+	//lotus-worker tasks 每次只接受一个任务类型
+	for _, tt := range tasks {
+		err := workerTaskCmd(ctx, hostname, miner, op, tt)
+		if err != nil {
+			return fmt.Errorf("%s task %s: %w", op, tt.Short(), err)
+		}
+	}
+
+	return nil
+}
+
+func workerTaskCmd(ctx context.Context, hostname, miner, op string, tt sealtasks.TaskType) error {
+	arg := fmt.Sprintf("lotus-worker --worker-repo=%s tasks %s %s", workerRepo(miner), op, tt.Short())
 
 	ansibleAdhocOptions := &adhoc.AnsibleAdhocOptions{
 		ModuleName: "shell",
@@ -29,16 +87,11 @@ func disableAPCmd(ctx context.Context, hostname, miner string) error {
 		Options: ansibleAdhocOptions,
 	}
 
-	log.Debugw("disableAPCmd", "Command: ", adhoc.String())
+	log.Debugw("workerTaskCmd", "Command: ", adhoc.String())
 
 	tctx, cancel := context.WithTimeout(ctx, RunCmdTimeout)
 	defer cancel()
-	err := adhoc.Run(tctx)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return adhoc.Run(tctx)
 }
 
 func copyScriptCmd(ctx context.Context, hostname, to, scriptsPath string) error {
@@ -76,6 +129,35 @@ func copyScriptCmd(ctx context.Context, hostname, to, scriptsPath string) error
 	return nil
 }
 
+// copyAPIInfoCmd 复制miner API信息到worker机器，只有root可以读取
+func copyAPIInfoCmd(ctx context.Context, hostname, to, scriptsPath, apiInfoDir string) error {
+	if build.SkipAnsible {
+		log.Debug("copyAPIInfoCmd test")
+		return nil
+	}
+	src := repo.APIInfoFile(scriptsPath, to)
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+
+	arg := fmt.Sprintf("src=%s dest=%s/ owner=root group=root mode=0600 directory_mode=0700", src, strings.TrimSuffix(apiInfoDir, "/"))
+	ansibleAdhocOptions := &adhoc.AnsibleAdhocOptions{
+		ModuleName: "copy",
+		Args:       arg,
+	}
+
+	adhoc := &adhoc.AnsibleAdhocCmd{
+		Pattern: hostname,
+		Options: ansibleAdhocOptions,
+	}
+
+	log.Debugw("copyAPIInfoCmd", "Command: ", adhoc.String())
+
+	tctx, cancel := context.WithTimeout(ctx, RunCmdTimeout)
+	defer cancel()
+	return adhoc.Run(tctx)
+}
+
 func workerRunCmd(ctx context.Context, hostname, to, scriptsPath string) error {
 	if build.SkipAnsible {
 		log.Debug("workerRunCmd test")
@@ -0,0 +1,561 @@
+package pilot
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
+	"github.com/google/uuid"
+)
+
+const SourceAutopilot = "autopilot"
+
+const (
+	AutopilotModeRatio   = "ratio"
+	AutopilotModeBacklog = "backlog"
+	AutopilotModeQuota   = "quota"
+)
+
+type autopilot struct {
+	conf         config.Autopilot
+	interval     time.Duration
+	targets      map[address.Address]int
+	role         WorkerRole
+	disableTasks []sealtasks.TaskType
+
+	lk     sync.Mutex
+	moves  []moveRecord
+	report AutopilotReport
+}
+
+type moveRecord struct {
+	time  time.Time
+	count int
+}
+
+// move 从from切换count个worker到to
+type move struct {
+	from  address.Address
+	to    address.Address
+	count int
+}
+
+// AutopilotReport 最近一轮autopilot的策略、依据和切换建议
+type AutopilotReport struct {
+	Time      time.Time                `json:"time"`
+	Config    config.Autopilot         `json:"config"`
+	Skip      string                   `json:"skip"`
+	Miners    map[string]MinerEvidence `json:"miners"`
+	Proposals []Proposal               `json:"proposals"`
+}
+
+// MinerEvidence autopilot计算每个miner期望worker数量的依据
+type MinerEvidence struct {
+	Workers int `json:"workers"`
+	Desired int `json:"desired"`
+	//ratio模式
+	Weight int `json:"weight"`
+	//backlog模式
+	Backlog int            `json:"backlog"`
+	Queued  map[string]int `json:"queued"`
+	Sectors map[string]int `json:"sectors"`
+	//quota模式，Rate为每小时完成的PC1数量，Projected为预计今天完成的数量
+	Quota     int     `json:"quota"`
+	Done      int     `json:"done"`
+	Rate      float64 `json:"rate"`
+	Projected float64 `json:"projected"`
+	Err       string  `json:"err"`
+}
+
+type Proposal struct {
+	From     address.Address `json:"from"`
+	To       address.Address `json:"to"`
+	Count    int             `json:"count"`
+	Reason   string          `json:"reason"`
+	SwitchID uuid.UUID       `json:"switchID"`
+	Err      string          `json:"err"`
+}
+
+func newAutopilot(conf config.Autopilot) (*autopilot, error) {
+	if !conf.Enable {
+		return nil, nil
+	}
+	if conf.Mode == "" {
+		conf.Mode = AutopilotModeRatio
+	}
+
+	ap := &autopilot{
+		conf:     conf,
+		interval: time.Duration(conf.Interval),
+		targets:  map[address.Address]int{},
+		role:     WorkerRole(conf.Role),
+	}
+	if ap.interval <= 0 {
+		return nil, fmt.Errorf("autopilot interval: %s illegal", ap.interval)
+	}
+	if conf.MaxMovesPerHour <= 0 {
+		return nil, fmt.Errorf("autopilot maxMovesPerHour: %d illegal", conf.MaxMovesPerHour)
+	}
+	if ap.role == "" {
+		ap.role = RoleSealing
+	}
+	if err := checkRole(ap.role); err != nil {
+		return nil, err
+	}
+
+	for miner, weight := range conf.Targets {
+		maddr, err := address.NewFromString(miner)
+		if err != nil {
+			return nil, err
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("autopilot target: %s weight: %d illegal", miner, weight)
+		}
+		ap.targets[maddr] = weight
+	}
+
+	switch conf.Mode {
+	case AutopilotModeRatio:
+		if len(ap.targets) < 2 {
+			return nil, fmt.Errorf("autopilot need at least 2 targets")
+		}
+	case AutopilotModeBacklog:
+		if conf.Backlog.SectorsPerWorker <= 0 {
+			return nil, fmt.Errorf("autopilot backlog sectorsPerWorker: %d illegal", conf.Backlog.SectorsPerWorker)
+		}
+		if conf.Backlog.IdleSectors >= conf.Backlog.BusySectors {
+			return nil, fmt.Errorf("autopilot backlog idleSectors: %d should less than busySectors: %d", conf.Backlog.IdleSectors, conf.Backlog.BusySectors)
+		}
+	case AutopilotModeQuota:
+		if len(conf.Quota.Sectors) == 0 {
+			return nil, fmt.Errorf("autopilot quota sectors is empty")
+		}
+		for miner, n := range conf.Quota.Sectors {
+			if _, err := address.NewFromString(miner); err != nil {
+				return nil, err
+			}
+			if n < 0 {
+				return nil, fmt.Errorf("autopilot quota: %s sectors: %d illegal", miner, n)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown autopilot mode: %s", conf.Mode)
+	}
+
+	for _, t := range conf.DisableTasks {
+		tt, err := ParseTaskType(t)
+		if err != nil {
+			return nil, err
+		}
+		ap.disableTasks = append(ap.disableTasks, tt)
+	}
+
+	return ap, nil
+}
+
+// budget 最近一小时内还可以切换的worker数量
+func (ap *autopilot) budget(now time.Time) int {
+	ap.lk.Lock()
+	defer ap.lk.Unlock()
+
+	var moves []moveRecord
+	used := 0
+	for _, m := range ap.moves {
+		if now.Sub(m.time) < time.Hour {
+			moves = append(moves, m)
+			used += m.count
+		}
+	}
+	ap.moves = moves
+
+	return ap.conf.MaxMovesPerHour - used
+}
+
+func (ap *autopilot) record(now time.Time, count int) {
+	ap.lk.Lock()
+	defer ap.lk.Unlock()
+
+	ap.moves = append(ap.moves, moveRecord{time: now, count: count})
+}
+
+func (ap *autopilot) setReport(r AutopilotReport) {
+	ap.lk.Lock()
+	defer ap.lk.Unlock()
+
+	ap.report = r
+}
+
+func (ap *autopilot) getReport() AutopilotReport {
+	ap.lk.Lock()
+	defer ap.lk.Unlock()
+
+	return ap.report
+}
+
+func (p *Pilot) runAutopilot() {
+	if p.ap == nil {
+		return
+	}
+	log.Infow("autopilot enabled", "mode", p.ap.conf.Mode, "dryRun", p.ap.conf.DryRun, "interval", p.ap.interval, "targets", p.ap.targets)
+
+	go func() {
+		t := time.NewTicker(p.ap.interval)
+		for {
+			select {
+			case <-t.C:
+				p.autopilotRound()
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (p *Pilot) autopilotRound() {
+	report := AutopilotReport{
+		Time:   p.clock(),
+		Config: p.ap.conf,
+		Miners: map[string]MinerEvidence{},
+	}
+	defer func() {
+		p.ap.setReport(report)
+	}()
+
+	if p.ap.conf.Mode == AutopilotModeQuota {
+		p.trackQuota()
+	}
+
+	//等待autopilot发起的切换完成，避免worker同时出现在两个miner上导致重复计算
+	if p.hasSwitchingSource(SourceAutopilot) {
+		report.Skip = "autopilot switch in progress"
+		log.Debug("autopilot skip: autopilot switch in progress")
+		return
+	}
+
+	var candidates []address.Address
+	if len(p.ap.targets) == 0 {
+		candidates = p.minerList()
+	}
+	for miner := range p.ap.targets {
+		if !p.hasMiner(miner) {
+			log.Warnw("autopilot skip target: miner not found", "miner", miner)
+			continue
+		}
+		candidates = append(candidates, miner)
+	}
+
+	//其他来源的切换(手动、撤离等)进行中时，只跳过涉及的miner
+	busy := p.switchingMiners()
+	var miners []address.Address
+	for _, miner := range candidates {
+		if _, ok := busy[miner]; ok {
+			report.Miners[miner.String()] = MinerEvidence{Err: "switch in progress"}
+			log.Debugw("autopilot skip miner: switch in progress", "miner", miner)
+			continue
+		}
+		miners = append(miners, miner)
+	}
+
+	counts, err := p.roleCount(miners, p.ap.role)
+	if err != nil {
+		report.Skip = err.Error()
+		log.Errorw("autopilot roleCount", "err", err)
+		return
+	}
+
+	var desired map[address.Address]int
+	switch p.ap.conf.Mode {
+	case AutopilotModeBacklog:
+		desired = p.backlogTargets(counts, report.Miners)
+	case AutopilotModeQuota:
+		desired = p.quotaTargets(counts, report.Miners)
+	default:
+		desired = p.ratioTargets(counts, report.Miners)
+	}
+	p.clampLimits(desired, report.Miners)
+
+	moves := planMoves(counts, desired, p.ap.conf.Hysteresis, p.ap.budget(p.clock()))
+	for _, m := range moves {
+		prop := Proposal{
+			From:   m.from,
+			To:     m.to,
+			Count:  m.count,
+			Reason: p.ap.reason(m, report.Miners),
+		}
+		req := SwitchRequest{
+			From:         m.from,
+			To:           m.to,
+			Count:        m.count,
+			DisableTasks: p.ap.disableTasks,
+			Role:         p.ap.role,
+			Source:       SourceAutopilot,
+		}
+		if p.ap.conf.DryRun {
+			log.Infow("autopilot dry run", "from", m.from, "to", m.to, "count", m.count, "reason", prop.Reason)
+			report.Proposals = append(report.Proposals, prop)
+			continue
+		}
+
+		ss, err := p.newSwitch(req)
+		if err != nil {
+			prop.Err = err.Error()
+			report.Proposals = append(report.Proposals, prop)
+			log.Errorw("autopilot newSwitch", "from", m.from, "to", m.to, "count", m.count, "err", err)
+			continue
+		}
+		//只有切换成功才占用每小时的切换数量
+		p.ap.record(p.clock(), m.count)
+		prop.SwitchID = ss.ID
+		report.Proposals = append(report.Proposals, prop)
+		log.Infow("autopilot switch", "switchID", ss.ID, "from", m.from, "to", m.to, "count", m.count, "reason", prop.Reason)
+	}
+}
+
+func (ap *autopilot) reason(m move, ev map[string]MinerEvidence) string {
+	from := ev[m.from.String()]
+	to := ev[m.to.String()]
+
+	if ap.conf.Mode == AutopilotModeQuota {
+		return fmt.Sprintf("from done: %d projected: %.1f quota: %d, to done: %d projected: %.1f quota: %d",
+			from.Done, from.Projected, from.Quota, to.Done, to.Projected, to.Quota)
+	}
+	if ap.conf.Mode == AutopilotModeBacklog {
+		return fmt.Sprintf("from backlog: %d <= idle: %d, to backlog: %d >= busy: %d",
+			from.Backlog, ap.conf.Backlog.IdleSectors, to.Backlog, ap.conf.Backlog.BusySectors)
+	}
+	return fmt.Sprintf("from workers: %d desired: %d, to workers: %d desired: %d",
+		from.Workers, from.Desired, to.Workers, to.Desired)
+}
+
+// ratioTargets ratio模式：按目标比例计算每个miner期望的worker数量
+func (p *Pilot) ratioTargets(counts map[address.Address]int, ev map[string]MinerEvidence) map[address.Address]int {
+	total := 0
+	weights := map[address.Address]int{}
+	for miner, c := range counts {
+		total += c
+		weights[miner] = p.ap.targets[miner]
+	}
+
+	desired := ratioTargets(total, weights)
+	for miner, c := range counts {
+		ev[miner.String()] = MinerEvidence{
+			Workers: c,
+			Desired: desired[miner],
+			Weight:  weights[miner],
+		}
+	}
+
+	return desired
+}
+
+// backlogTargets backlog模式：pipeline为空的miner只保留MinWorkers个worker，
+// 积压超过BusySectors的miner按积压数量增加worker
+func (p *Pilot) backlogTargets(counts map[address.Address]int, ev map[string]MinerEvidence) map[address.Address]int {
+	conf := p.ap.conf.Backlog
+	spec := roleSpecs[p.ap.role]
+
+	desired := map[address.Address]int{}
+	for miner, c := range counts {
+		e := MinerEvidence{
+			Workers: c,
+			Desired: c,
+			Queued:  map[string]int{},
+			Sectors: map[string]int{},
+		}
+
+		diag, summary, err := p.minerPipeline(miner)
+		if err != nil {
+			//没有依据时不切换这个miner
+			e.Err = err.Error()
+			ev[miner.String()] = e
+			desired[miner] = c
+			log.Warnw("autopilot minerPipeline", "miner", miner, "err", err)
+			continue
+		}
+
+		for _, req := range diag.Requests {
+			for _, t := range spec.backlogTasks {
+				if req.TaskType.Short() == t {
+					e.Queued[t] += 1
+					e.Backlog += 1
+				}
+			}
+		}
+		for _, st := range spec.backlogStates {
+			if n := summary[st]; n != 0 {
+				e.Sectors[st] = n
+				e.Backlog += n
+			}
+		}
+
+		e.Desired = backlogDesired(c, e.Backlog, conf)
+		desired[miner] = e.Desired
+		ev[miner.String()] = e
+	}
+
+	return desired
+}
+
+// backlogDesired 积压不超过IdleSectors时只保留MinWorkers个worker，
+// 积压超过BusySectors时每SectorsPerWorker个积压增加一个worker，其他情况保持不变
+func backlogDesired(workers, backlog int, conf config.Backlog) int {
+	switch {
+	case backlog <= conf.IdleSectors:
+		return min(workers, conf.MinWorkers)
+	case backlog >= conf.BusySectors:
+		return workers + (backlog+conf.SectorsPerWorker-1)/conf.SectorsPerWorker
+	}
+	return workers
+}
+
+// minerPipeline 获取miner调度队列和sector状态统计
+func (p *Pilot) minerPipeline(ma address.Address) (SchedDiagInfo, map[string]int, error) {
+	snap, err := p.snapshot(ma)
+	if err != nil {
+		return SchedDiagInfo{}, nil, err
+	}
+
+	api, err := p.minerAPI(ma)
+	if err != nil {
+		return SchedDiagInfo{}, nil, err
+	}
+	ctx, cancel := p.rpcContext()
+	defer cancel()
+
+	summary, err := api.SectorsSummary(ctx)
+	if err != nil {
+		return SchedDiagInfo{}, nil, err
+	}
+
+	out := map[string]int{}
+	for st, n := range summary {
+		out[string(st)] = n
+	}
+
+	return snap.diag, out, nil
+}
+
+func (p *Pilot) autopilotReport() (AutopilotReport, error) {
+	if p.ap == nil {
+		return AutopilotReport{}, fmt.Errorf("autopilot not enabled")
+	}
+	return p.ap.getReport(), nil
+}
+
+// roleCount 统计每个miner中指定角色的worker数量
+func (p *Pilot) roleCount(miners []address.Address, role WorkerRole) (map[address.Address]int, error) {
+	out := map[address.Address]int{}
+	for _, miner := range miners {
+		st, err := p.getWorkerStats(miner)
+		if err != nil {
+			return nil, err
+		}
+
+		count := 0
+		for _, w := range st {
+			if hasRole(w, role) {
+				count += 1
+			}
+		}
+		out[miner] = count
+	}
+
+	return out, nil
+}
+
+// ratioTargets 按比例分配total个worker，余数分配给小数部分最大的miner
+func ratioTargets(total int, weights map[address.Address]int) map[address.Address]int {
+	sum := 0
+	for _, w := range weights {
+		sum += w
+	}
+
+	out := map[address.Address]int{}
+	if sum == 0 {
+		return out
+	}
+
+	type rem struct {
+		miner address.Address
+		frac  int
+	}
+	var rems []rem
+	assigned := 0
+	for miner, w := range weights {
+		out[miner] = total * w / sum
+		assigned += out[miner]
+		rems = append(rems, rem{miner: miner, frac: total * w % sum})
+	}
+
+	sort.Slice(rems, func(i, j int) bool {
+		if rems[i].frac != rems[j].frac {
+			return rems[i].frac > rems[j].frac
+		}
+		return rems[i].miner.String() < rems[j].miner.String()
+	})
+	for i := 0; i < total-assigned; i++ {
+		out[rems[i].miner] += 1
+	}
+
+	return out
+}
+
+// planMoves 当某个miner与目标的差距超过hysteresis时，从多出的miner切换到不足的miner
+// 总切换数量不超过budget
+func planMoves(counts, desired map[address.Address]int, hysteresis, budget int) []move {
+	type diff struct {
+		miner address.Address
+		n     int
+	}
+	var donors, receivers []diff
+	trigger := false
+	for miner, want := range desired {
+		d := counts[miner] - want
+		if d > hysteresis || -d > hysteresis {
+			trigger = true
+		}
+		if d > 0 {
+			donors = append(donors, diff{miner: miner, n: d})
+		}
+		if d < 0 {
+			receivers = append(receivers, diff{miner: miner, n: -d})
+		}
+	}
+	if !trigger {
+		return nil
+	}
+
+	byN := func(ds []diff) func(i, j int) bool {
+		return func(i, j int) bool {
+			if ds[i].n != ds[j].n {
+				return ds[i].n > ds[j].n
+			}
+			return ds[i].miner.String() < ds[j].miner.String()
+		}
+	}
+	sort.Slice(donors, byN(donors))
+	sort.Slice(receivers, byN(receivers))
+
+	var out []move
+	i, j := 0, 0
+	for i < len(donors) && j < len(receivers) && budget > 0 {
+		n := min(donors[i].n, receivers[j].n, budget)
+		out = append(out, move{from: donors[i].miner, to: receivers[j].miner, count: n})
+
+		donors[i].n -= n
+		receivers[j].n -= n
+		budget -= n
+		if donors[i].n == 0 {
+			i++
+		}
+		if receivers[j].n == 0 {
+			j++
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,161 @@
+package pilot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
+)
+
+func testMiners(t *testing.T, n int) []address.Address {
+	var out []address.Address
+	for i := 0; i < n; i++ {
+		ma, err := address.NewIDAddress(uint64(1000 + i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, ma)
+	}
+	return out
+}
+
+func TestRatioTargets(t *testing.T) {
+	m := testMiners(t, 3)
+	a, b, c := m[0], m[1], m[2]
+
+	tests := []struct {
+		name    string
+		total   int
+		weights map[address.Address]int
+		expect  map[address.Address]int
+	}{
+		{"exact", 10, map[address.Address]int{a: 50, b: 30, c: 20}, map[address.Address]int{a: 5, b: 3, c: 2}},
+		{"remainder to largest fraction", 5, map[address.Address]int{a: 3, b: 3, c: 1}, map[address.Address]int{a: 2, b: 2, c: 1}},
+		{"tie by miner", 7, map[address.Address]int{a: 1, b: 1, c: 1}, map[address.Address]int{a: 3, b: 2, c: 2}},
+		{"two miners", 9, map[address.Address]int{a: 2, b: 1}, map[address.Address]int{a: 6, b: 3}},
+		{"zero weight", 4, map[address.Address]int{a: 1, b: 0}, map[address.Address]int{a: 4, b: 0}},
+		{"no worker", 0, map[address.Address]int{a: 1, b: 1}, map[address.Address]int{a: 0, b: 0}},
+		{"all weights zero", 4, map[address.Address]int{a: 0, b: 0}, map[address.Address]int{}},
+	}
+
+	for _, tt := range tests {
+		got := ratioTargets(tt.total, tt.weights)
+		if !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("%s: got: %v expect: %v", tt.name, got, tt.expect)
+		}
+	}
+}
+
+func TestPlanMoves(t *testing.T) {
+	m := testMiners(t, 4)
+	a, b, c, d := m[0], m[1], m[2], m[3]
+
+	tests := []struct {
+		name       string
+		counts     map[address.Address]int
+		desired    map[address.Address]int
+		hysteresis int
+		budget     int
+		expect     []move
+	}{
+		{
+			name:       "within hysteresis",
+			counts:     map[address.Address]int{a: 5, b: 5},
+			desired:    map[address.Address]int{a: 4, b: 6},
+			hysteresis: 1,
+			budget:     10,
+		},
+		{
+			name:       "balanced",
+			counts:     map[address.Address]int{a: 5, b: 5},
+			desired:    map[address.Address]int{a: 5, b: 5},
+			hysteresis: 0,
+			budget:     10,
+		},
+		{
+			name:       "move",
+			counts:     map[address.Address]int{a: 8, b: 2},
+			desired:    map[address.Address]int{a: 5, b: 5},
+			hysteresis: 1,
+			budget:     10,
+			expect:     []move{{from: a, to: b, count: 3}},
+		},
+		{
+			name:       "budget exhausted",
+			counts:     map[address.Address]int{a: 8, b: 2},
+			desired:    map[address.Address]int{a: 5, b: 5},
+			hysteresis: 1,
+			budget:     2,
+			expect:     []move{{from: a, to: b, count: 2}},
+		},
+		{
+			name:       "no budget",
+			counts:     map[address.Address]int{a: 8, b: 2},
+			desired:    map[address.Address]int{a: 5, b: 5},
+			hysteresis: 1,
+			budget:     0,
+		},
+		{
+			name:       "multiple donors",
+			counts:     map[address.Address]int{a: 10, b: 4, c: 0, d: 6},
+			desired:    map[address.Address]int{a: 5, b: 4, c: 6, d: 5},
+			hysteresis: 2,
+			budget:     10,
+			expect:     []move{{from: a, to: c, count: 5}, {from: d, to: c, count: 1}},
+		},
+		{
+			name:       "multiple donors budget exhausted",
+			counts:     map[address.Address]int{a: 10, b: 4, c: 0, d: 6},
+			desired:    map[address.Address]int{a: 5, b: 4, c: 6, d: 5},
+			hysteresis: 2,
+			budget:     5,
+			expect:     []move{{from: a, to: c, count: 5}},
+		},
+		{
+			name:       "multiple receivers",
+			counts:     map[address.Address]int{a: 9, b: 1, c: 2},
+			desired:    map[address.Address]int{a: 4, b: 3, c: 5},
+			hysteresis: 1,
+			budget:     4,
+			expect:     []move{{from: a, to: c, count: 3}, {from: a, to: b, count: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		got := planMoves(tt.counts, tt.desired, tt.hysteresis, tt.budget)
+		if !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("%s: got: %+v expect: %+v", tt.name, got, tt.expect)
+		}
+	}
+}
+
+func TestBacklogDesired(t *testing.T) {
+	conf := config.Backlog{
+		IdleSectors:      2,
+		BusySectors:      10,
+		SectorsPerWorker: 4,
+		MinWorkers:       1,
+	}
+
+	tests := []struct {
+		name    string
+		workers int
+		backlog int
+		expect  int
+	}{
+		{"empty backlog", 5, 0, 1},
+		{"empty backlog without worker", 0, 0, 0},
+		{"idle", 5, 2, 1},
+		{"between idle and busy", 5, 6, 5},
+		{"busy", 5, 12, 8},
+		{"busy round up", 5, 13, 9},
+	}
+
+	for _, tt := range tests {
+		got := backlogDesired(tt.workers, tt.backlog, conf)
+		if got != tt.expect {
+			t.Errorf("%s: got: %d expect: %d", tt.name, got, tt.expect)
+		}
+	}
+}
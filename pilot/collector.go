@@ -0,0 +1,253 @@
+package pilot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/gh-efforts/lotus-pilot/metrics"
+	"github.com/google/uuid"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// minerSnapshot 某一时刻miner的worker数据，发布后不再修改，读取的一方也不能修改
+type minerSnapshot struct {
+	time  time.Time
+	stats wst
+	jobs  jobs
+	sts   sts
+	diag  SchedDiagInfo
+	//只包含启用的sealing worker，包含任务被禁止后暂时没有角色的worker
+	info map[uuid.UUID]WorkerInfo
+}
+
+// SnapshotStatus miner snapshot的时间和最近一次获取的错误
+type SnapshotStatus struct {
+	Miner   string    `json:"miner"`
+	Time    time.Time `json:"time"`
+	Age     float64   `json:"age"` //seconds
+	Workers int       `json:"workers"`
+	LastErr string    `json:"lastErr"`
+}
+
+type fetchCall struct {
+	done chan struct{}
+	snap *minerSnapshot
+	err  error
+}
+
+// collector 保存每个miner最近一次成功获取的snapshot，同一个miner同时只有一个获取请求
+type collector struct {
+	lk       sync.Mutex
+	snaps    map[address.Address]*minerSnapshot
+	lastErr  map[address.Address]error
+	inflight map[address.Address]*fetchCall
+}
+
+func newCollector() *collector {
+	return &collector{
+		snaps:    map[address.Address]*minerSnapshot{},
+		lastErr:  map[address.Address]error{},
+		inflight: map[address.Address]*fetchCall{},
+	}
+}
+
+func (c *collector) get(ma address.Address) *minerSnapshot {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	return c.snaps[ma]
+}
+
+func (c *collector) remove(ma address.Address) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	delete(c.snaps, ma)
+	delete(c.lastErr, ma)
+}
+
+// fetch 获取新的snapshot，已经有获取中的请求时等待它的结果。
+// 获取期间miner可能已经被删除，exists检查miner仍然存在时才保存结果，exists不能获取c.lk
+func (c *collector) fetch(ma address.Address, fn func() (*minerSnapshot, error), exists func() bool) (*minerSnapshot, error) {
+	c.lk.Lock()
+	if call, ok := c.inflight[ma]; ok {
+		c.lk.Unlock()
+		<-call.done
+		return call.snap, call.err
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	c.inflight[ma] = call
+	c.lk.Unlock()
+
+	call.snap, call.err = fn()
+
+	c.lk.Lock()
+	delete(c.inflight, ma)
+	if !exists() {
+		log.Debugw("miner removed, drop snapshot", "miner", ma)
+	} else if call.err == nil {
+		c.snaps[ma] = call.snap
+		delete(c.lastErr, ma)
+	} else {
+		c.lastErr[ma] = call.err
+	}
+	c.lk.Unlock()
+	close(call.done)
+
+	return call.snap, call.err
+}
+
+func (c *collector) status(now time.Time) []SnapshotStatus {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	var out []SnapshotStatus
+	seen := map[address.Address]struct{}{}
+	for ma, snap := range c.snaps {
+		s := SnapshotStatus{
+			Miner:   ma.String(),
+			Time:    snap.time,
+			Age:     now.Sub(snap.time).Seconds(),
+			Workers: len(snap.info),
+		}
+		if err, ok := c.lastErr[ma]; ok {
+			s.LastErr = err.Error()
+		}
+		out = append(out, s)
+		seen[ma] = struct{}{}
+	}
+	for ma, err := range c.lastErr {
+		if _, ok := seen[ma]; ok {
+			continue
+		}
+		out = append(out, SnapshotStatus{Miner: ma.String(), LastErr: err.Error()})
+	}
+	return out
+}
+
+// snapshot 返回miner的snapshot，超过cacheTimeout时重新获取
+func (p *Pilot) snapshot(ma address.Address) (*minerSnapshot, error) {
+	snap := p.collector.get(ma)
+	if snap != nil && p.clock().Sub(snap.time) < p.cacheTimeout {
+		return snap, nil
+	}
+	return p.refresh(ma)
+}
+
+// refresh 立即获取miner的snapshot
+func (p *Pilot) refresh(ma address.Address) (*minerSnapshot, error) {
+	return p.collector.fetch(ma, func() (*minerSnapshot, error) {
+		return p.collect(ma)
+	}, func() bool {
+		return p.hasMiner(ma)
+	})
+}
+
+func (p *Pilot) collect(ma address.Address) (*minerSnapshot, error) {
+	api, err := p.minerAPI(ma)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := p.rpcContext()
+	defer cancel()
+
+	now := p.clock()
+	wst, jobs, sts, diag, err := minerWorkerInfo(ctx, api)
+	p.recordHealth(ma, wst, err)
+	if err != nil {
+		return nil, err
+	}
+
+	//封存路径容量单独并发获取，不使用上面的ctx
+	paths := p.statSealPaths(api, wst)
+
+	snap := &minerSnapshot{
+		time:  now,
+		stats: wst,
+		jobs:  jobs,
+		sts:   sts,
+		diag:  diag,
+		info:  p.buildWorkerInfo(wst, jobs, sts, diag, paths),
+	}
+	p.durations.observe(ma, p.minerSectorSize(ma), wst, jobs, now)
+	return snap, nil
+}
+
+// minerAPI 返回miner的API，调用API时不持有p.lk
+func (p *Pilot) minerAPI(ma address.Address) (v0api.StorageMiner, error) {
+	p.lk.RLock()
+	defer p.lk.RUnlock()
+
+	mi, ok := p.miners[ma]
+	if !ok {
+		return nil, fmt.Errorf("not found miner: %s", ma)
+	}
+	if err := p.conns.usable(ma); err != nil {
+		return nil, err
+	}
+	return mi.api, nil
+}
+
+// rpcContext 每次调用miner API的超时，rpcTimeout为0时不限制
+func (p *Pilot) rpcContext() (context.Context, context.CancelFunc) {
+	if p.rpcTimeout <= 0 {
+		return context.WithCancel(p.ctx)
+	}
+	return context.WithTimeout(p.ctx, p.rpcTimeout)
+}
+
+// runCollector 按collectInterval在后台获取所有miner的snapshot
+func (p *Pilot) runCollector(interval time.Duration) {
+	if interval <= 0 {
+		interval = p.cacheTimeout
+	}
+	if interval <= 0 {
+		log.Warnw("collect interval illegal, background collector disabled", "interval", interval)
+		return
+	}
+
+	go func() {
+		t := time.NewTicker(interval)
+		for {
+			select {
+			case <-t.C:
+				p.collectAll()
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (p *Pilot) collectAll() {
+	var wg sync.WaitGroup
+	for _, miner := range p.minerList() {
+		wg.Add(1)
+		go func(ma address.Address) {
+			defer wg.Done()
+
+			_, err := p.refresh(ma)
+			if err != nil {
+				log.Warnw("collect snapshot", "miner", ma, "err", err)
+			}
+		}(miner)
+	}
+	wg.Wait()
+
+	now := p.clock()
+	for _, s := range p.collector.status(now) {
+		ctx, err := tag.New(context.Background(), tag.Upsert(metrics.Miner, s.Miner))
+		if err != nil {
+			continue
+		}
+		if !s.Time.IsZero() {
+			stats.Record(ctx, metrics.SnapshotAge.M(s.Age), metrics.MinerWorkers.M(int64(s.Workers)))
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package pilot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/gh-efforts/lotus-pilot/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// ConnState miner API的连接状态
+type ConnState string
+
+const (
+	ConnStateConnected    ConnState = "connected"
+	ConnStateDisconnected ConnState = "disconnected"
+	//API返回的actor地址和配置不一致，重连成功前不使用这个miner的API
+	ConnStateMismatch ConnState = "mismatch"
+)
+
+const (
+	minReconnectBackoff = 5 * time.Second
+	maxReconnectBackoff = 5 * time.Minute
+)
+
+var errActorMismatch = errors.New("actor address not match")
+
+// ConnStatus miner API的连接状态，Latency为最近一次探测的耗时
+type ConnStatus struct {
+	Miner      string    `json:"miner"`
+	Addr       string    `json:"addr"`
+	State      ConnState `json:"state"`
+	Version    string    `json:"version"`
+	LastErr    string    `json:"lastErr"`
+	Latency    float64   `json:"latency"` //milliseconds
+	LastProbe  time.Time `json:"lastProbe"`
+	LastOK     time.Time `json:"lastOK"`
+	Reconnects int       `json:"reconnects"`
+	NextRetry  time.Time `json:"nextRetry"`
+}
+
+type minerConn struct {
+	status  ConnStatus
+	backoff time.Duration
+}
+
+// connManager 记录每个miner API的连接状态和重连的退避时间
+type connManager struct {
+	lk    sync.Mutex
+	conns map[address.Address]*minerConn
+}
+
+func newConnManager() *connManager {
+	return &connManager{
+		conns: map[address.Address]*minerConn{},
+	}
+}
+
+func (cm *connManager) add(ma address.Address, addr string, now time.Time) {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	cm.conns[ma] = &minerConn{
+		status: ConnStatus{
+			Miner:  ma.String(),
+			Addr:   addr,
+			State:  ConnStateConnected,
+			LastOK: now,
+		},
+	}
+}
+
+func (cm *connManager) remove(ma address.Address) {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	delete(cm.conns, ma)
+}
+
+// usable 状态为mismatch时不能使用miner的API，没有记录的miner可以使用
+func (cm *connManager) usable(ma address.Address) error {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	c, ok := cm.conns[ma]
+	if !ok || c.status.State != ConnStateMismatch {
+		return nil
+	}
+	return fmt.Errorf("miner: %s api %s: %s", ma, c.status.State, c.status.LastErr)
+}
+
+func (cm *connManager) probeOK(ma address.Address, version string, latency time.Duration, now time.Time) {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	c, ok := cm.conns[ma]
+	if !ok {
+		return
+	}
+	if c.status.State != ConnStateConnected {
+		log.Infow("miner api connected", "miner", ma)
+	}
+	c.status.State = ConnStateConnected
+	c.status.Version = version
+	c.status.LastErr = ""
+	c.status.Latency = float64(latency.Nanoseconds()) / 1e6
+	c.status.LastProbe = now
+	c.status.LastOK = now
+	c.status.NextRetry = time.Time{}
+	c.backoff = 0
+}
+
+// probeFailed 记录探测失败，返回是否到了重连的时间
+func (cm *connManager) probeFailed(ma address.Address, err error, now time.Time) bool {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	c, ok := cm.conns[ma]
+	if !ok {
+		return false
+	}
+	state := ConnStateDisconnected
+	if errors.Is(err, errActorMismatch) {
+		state = ConnStateMismatch
+	}
+	if c.status.State != state {
+		log.Warnw("miner api "+string(state), "miner", ma, "err", err)
+	}
+	c.status.State = state
+	c.status.LastErr = err.Error()
+	c.status.LastProbe = now
+	return !now.Before(c.status.NextRetry)
+}
+
+// reconnectFailed 重连失败，退避时间加倍
+func (cm *connManager) reconnectFailed(ma address.Address, err error, now time.Time) {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	c, ok := cm.conns[ma]
+	if !ok {
+		return
+	}
+	c.backoff = min(max(c.backoff*2, minReconnectBackoff), maxReconnectBackoff)
+	c.status.LastErr = err.Error()
+	c.status.NextRetry = now.Add(c.backoff)
+	log.Warnw("reconnect miner api", "miner", ma, "err", err, "nextRetry", c.status.NextRetry)
+}
+
+func (cm *connManager) reconnected(ma address.Address, now time.Time) {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	c, ok := cm.conns[ma]
+	if !ok {
+		return
+	}
+	c.status.State = ConnStateConnected
+	c.status.LastErr = ""
+	c.status.LastOK = now
+	c.status.NextRetry = time.Time{}
+	c.status.Reconnects += 1
+	c.backoff = 0
+	log.Infow("miner api reconnected", "miner", ma, "reconnects", c.status.Reconnects)
+}
+
+func (cm *connManager) list() []ConnStatus {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	var out []ConnStatus
+	for _, c := range cm.conns {
+		out = append(out, c.status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Miner < out[j].Miner })
+	return out
+}
+
+// probeAPI 调用Version和ActorAddress，actor地址和ma不一致时返回errActorMismatch
+func probeAPI(ctx context.Context, api v0api.StorageMiner, ma address.Address) (string, error) {
+	v, err := api.Version(ctx)
+	if err != nil {
+		return "", err
+	}
+	apiAddr, err := api.ActorAddress(ctx)
+	if err != nil {
+		return "", err
+	}
+	if apiAddr != ma {
+		return "", fmt.Errorf("%w, config maddr: %s, api maddr: %s", errActorMismatch, ma, apiAddr)
+	}
+	return v.Version, nil
+}
+
+// runConnManager 按probeInterval探测所有miner的API，失败时按退避时间重连
+func (p *Pilot) runConnManager(interval time.Duration) {
+	if interval <= 0 {
+		log.Warnw("probe interval illegal, miner api probe disabled", "interval", interval)
+		return
+	}
+
+	go func() {
+		t := time.NewTicker(interval)
+		for {
+			select {
+			case <-t.C:
+				p.probeAll()
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (p *Pilot) probeAll() {
+	var wg sync.WaitGroup
+	for _, miner := range p.minerList() {
+		wg.Add(1)
+		go func(ma address.Address) {
+			defer wg.Done()
+			p.probeMiner(ma)
+		}(miner)
+	}
+	wg.Wait()
+}
+
+func (p *Pilot) probeMiner(ma address.Address) {
+	p.lk.RLock()
+	mi, ok := p.miners[ma]
+	p.lk.RUnlock()
+	if !ok {
+		return
+	}
+
+	ctx, cancel := p.rpcContext()
+	start := p.clock()
+	version, err := probeAPI(ctx, mi.api, ma)
+	latency := p.clock().Sub(start)
+	cancel()
+
+	connected := int64(0)
+	now := p.clock()
+	if err == nil {
+		connected = 1
+		p.conns.probeOK(ma, version, latency, now)
+	} else if p.conns.probeFailed(ma, err, now) {
+		err = p.reconnectMiner(mi)
+		if err != nil {
+			p.conns.reconnectFailed(ma, err, p.clock())
+		} else {
+			connected = 1
+			p.conns.reconnected(ma, p.clock())
+		}
+	}
+
+	mctx, err := tag.New(context.Background(), tag.Upsert(metrics.Miner, ma.String()))
+	if err != nil {
+		return
+	}
+	stats.Record(mctx, metrics.MinerConnected.M(connected))
+	if connected == 1 {
+		stats.Record(mctx, metrics.MinerProbeLatency.M(float64(latency.Nanoseconds())/1e6))
+	}
+}
+
+// reconnectMiner 重新连接miner API，检查actor地址后替换旧的连接
+func (p *Pilot) reconnectMiner(mi MinerInfo) error {
+	//重新读取token引用，token更新后重连使用新的token
+	ep, err := resolveEndpoint(p.repo, mi.info)
+	if err != nil {
+		return err
+	}
+	api, closer, err := dialMiner(p.ctx, mi.address, ep, mi.info.CAFile, p.rpcTimeout)
+	if err != nil {
+		return err
+	}
+
+	p.lk.Lock()
+	cur, ok := p.miners[mi.address]
+	if !ok {
+		p.lk.Unlock()
+		closer()
+		return fmt.Errorf("not found miner: %s", mi.address)
+	}
+	old := cur.closer
+	cur.api = api
+	cur.closer = closer
+	//保存新的token，之后生成的脚本和状态输出使用新的token
+	cur.token = ep.APIInfo()
+	p.miners[mi.address] = cur
+	p.lk.Unlock()
+
+	//先替换再关闭旧连接，关闭时不持有锁，正在使用旧连接的请求会失败后重试
+	if old != nil {
+		old()
+	}
+	return nil
+}
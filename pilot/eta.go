@@ -0,0 +1,344 @@
+package pilot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
+	"github.com/google/uuid"
+)
+
+// durationAlpha 任务耗时的指数移动平均系数
+const durationAlpha = 0.2
+
+// taskChain sector完成任务后还要在同一台worker上执行的任务，用于估算剩余时间
+var taskChain = map[string][]string{
+	"AP":  {"PC1", "PC2"},
+	"PC1": {"PC2"},
+	"RU":  {"PR1", "PR2"},
+	"PR1": {"PR2"},
+	"C1":  {"C2"},
+}
+
+// TaskDuration 任务的平均耗时
+type TaskDuration struct {
+	Avg     config.Duration `json:"avg"`
+	Samples int             `json:"samples"`
+}
+
+type runningJob struct {
+	Start time.Time `json:"start"`
+	//最近一次看到任务在运行的时间
+	Seen time.Time `json:"seen"`
+	//运行任务的worker，worker消失时任务不算完成
+	Worker uuid.UUID `json:"worker"`
+}
+
+// durationTracker 根据WorkerJobs中运行的任务的开始和消失学习每个miner、sector大小的任务耗时
+type durationTracker struct {
+	lk sync.Mutex
+	//上一次看到正在运行的任务，key为miner和 sector/task
+	Running map[string]map[string]runningJob `json:"running"`
+	//key为 miner/sectorSize/task
+	Durations map[string]*TaskDuration `json:"durations"`
+}
+
+func loadDurationTracker(data []byte) (*durationTracker, error) {
+	dt := &durationTracker{}
+	err := json.Unmarshal(data, dt)
+	if err != nil {
+		return nil, err
+	}
+	if dt.Running == nil {
+		dt.Running = map[string]map[string]runningJob{}
+	}
+	if dt.Durations == nil {
+		dt.Durations = map[string]*TaskDuration{}
+	}
+	return dt, nil
+}
+
+func durationKey(miner address.Address, size abi.SectorSize, task string) string {
+	return fmt.Sprintf("%s/%s/%s", miner, size.ShortString(), task)
+}
+
+// observe 记录一次WorkerStats和WorkerJobs，上次运行中的任务这次不在运行则认为完成，
+// 完成时间取上次看到和这次之间的中点。miner重启等情况下没有worker或者任务时不记录，
+// worker已经消失的任务不算完成
+func (dt *durationTracker) observe(miner address.Address, size abi.SectorSize, wst wst, jobs jobs, now time.Time) {
+	if len(wst) == 0 || len(jobs) == 0 {
+		return
+	}
+
+	dt.lk.Lock()
+	defer dt.lk.Unlock()
+
+	running := map[string]runningJob{}
+	for wid, js := range jobs {
+		for _, job := range js {
+			if job.RunWait != storiface.RWRunning || job.Task.Short() == "" {
+				continue
+			}
+			key := fmt.Sprintf("%d/%s", job.Sector.Number, job.Task.Short())
+			running[key] = runningJob{Start: job.Start, Seen: now, Worker: wid}
+		}
+	}
+
+	for key, last := range dt.Running[miner.String()] {
+		if _, ok := running[key]; ok {
+			continue
+		}
+		if _, ok := wst[last.Worker]; !ok {
+			continue
+		}
+		_, task, ok := strings.Cut(key, "/")
+		if !ok {
+			continue
+		}
+		done := last.Seen.Add(now.Sub(last.Seen) / 2)
+		d := done.Sub(last.Start)
+		if d <= 0 {
+			continue
+		}
+		dk := durationKey(miner, size, task)
+		td, ok := dt.Durations[dk]
+		if !ok {
+			dt.Durations[dk] = &TaskDuration{Avg: config.Duration(d), Samples: 1}
+			continue
+		}
+		td.Avg = config.Duration(float64(td.Avg) + (float64(d)-float64(td.Avg))*durationAlpha)
+		td.Samples += 1
+	}
+	dt.Running[miner.String()] = running
+}
+
+func (dt *durationTracker) get(miner address.Address, size abi.SectorSize, task string) (time.Duration, bool) {
+	dt.lk.Lock()
+	defer dt.lk.Unlock()
+
+	td, ok := dt.Durations[durationKey(miner, size, task)]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(td.Avg), true
+}
+
+func (dt *durationTracker) list() map[string]TaskDuration {
+	dt.lk.Lock()
+	defer dt.lk.Unlock()
+
+	out := map[string]TaskDuration{}
+	for k, td := range dt.Durations {
+		out[k] = *td
+	}
+	return out
+}
+
+func (dt *durationTracker) marshal() ([]byte, error) {
+	dt.lk.Lock()
+	defer dt.lk.Unlock()
+
+	return json.Marshal(dt)
+}
+
+// remaining 估算worker完成tasks中所有任务的时间，运行中的任务按最近一次开始时间计算剩余时间，
+// 等待中的任务按完整耗时计算，再加上sector在worker上后续的任务。缺少耗时数据时known为false
+func (dt *durationTracker) remaining(miner address.Address, size abi.SectorSize, w WorkerInfo, tasks map[string]struct{}, now time.Time) (time.Duration, bool) {
+	var out time.Duration
+	known := true
+	for t := range tasks {
+		running := w.Runing[t]
+		pending := w.sum(t) - running
+		if running+pending == 0 {
+			continue
+		}
+		avg, ok := dt.get(miner, size, t)
+		if !ok {
+			known = false
+			continue
+		}
+
+		var d time.Duration
+		if running != 0 {
+			d = max(avg-now.Sub(w.LastStart[t]), 0)
+		}
+		if pending != 0 {
+			d = avg
+		}
+		for _, next := range taskChain[t] {
+			if _, ok := tasks[next]; !ok {
+				continue
+			}
+			na, ok := dt.get(miner, size, next)
+			if !ok {
+				known = false
+				continue
+			}
+			d += na
+		}
+		out = max(out, d)
+	}
+	return out, known
+}
+
+// WorkerETA worker预计切换和停止的剩余时间
+type WorkerETA struct {
+	Switch config.Duration `json:"switch"`
+	Stop   config.Duration `json:"stop"`
+	//缺少任务耗时数据时为false，估算偏小
+	Known bool `json:"known"`
+}
+
+// estimateWorker 根据from的策略估算worker的切换和停止时间，停止条件中的sector不计算
+func (p *Pilot) estimateWorker(req SwitchRequest, from address.Address, w WorkerInfo) WorkerETA {
+	pol := p.policyFor(req, from)
+	size := p.minerSectorSize(from)
+	now := p.clock()
+
+	switchTasks := map[string]struct{}{}
+	for _, t := range pol.SwitchTasks {
+		switchTasks[t] = struct{}{}
+	}
+	stopTasks := map[string]struct{}{}
+	if pol.StopAllTasks {
+		for _, m := range []map[string]int{w.Runing, w.Prepared, w.Assigned, w.Sched} {
+			for t := range m {
+				stopTasks[t] = struct{}{}
+			}
+		}
+	} else {
+		for _, t := range pol.StopTasks {
+			stopTasks[t] = struct{}{}
+		}
+	}
+
+	sw, swKnown := p.durations.remaining(from, size, w, switchTasks, now)
+	stop, stopKnown := p.durations.remaining(from, size, w, stopTasks, now)
+	return WorkerETA{
+		Switch: config.Duration(sw),
+		Stop:   config.Duration(max(sw, stop)),
+		Known:  swKnown && stopKnown,
+	}
+}
+
+// estimatePicked 记录每个选择的worker在选择时预计的完成时间，用于计算进度
+func (p *Pilot) estimatePicked(req SwitchRequest, worker map[uuid.UUID]*WorkerState) {
+	if req.Type == SwitchTypeAttach || req.Type == SwitchTypeEvacuate {
+		return
+	}
+	infos := map[address.Address]map[uuid.UUID]WorkerInfo{}
+	for wid, ws := range worker {
+		info, ok := infos[ws.From]
+		if !ok {
+			var err error
+			info, err = p.getWorkerInfo(ws.From)
+			if err != nil {
+				log.Warnw("estimatePicked getWorkerInfo", "from", ws.From, "err", err)
+				continue
+			}
+			infos[ws.From] = info
+		}
+		w, ok := info[wid]
+		if !ok {
+			continue
+		}
+		ws.Estimate = p.estimateWorker(req, ws.From, w).Stop
+	}
+}
+
+// SwitchProgress switch预计的完成时间和进度
+type SwitchProgress struct {
+	ETA       time.Time       `json:"eta"`
+	Remaining config.Duration `json:"remaining"`
+	//0-100
+	Percent float64 `json:"percent"`
+	//有worker缺少任务耗时数据或者无法获取worker信息时为false
+	Known bool `json:"known"`
+}
+
+// switchProgress 返回带有预计完成时间和进度的switch副本
+func (p *Pilot) switchProgress(ss *SwitchState) *SwitchState {
+	p.swLk.RLock()
+	out := *ss
+	out.Worker = map[uuid.UUID]*WorkerState{}
+	for wid, ws := range ss.Worker {
+		w := *ws
+		out.Worker[wid] = &w
+	}
+	p.swLk.RUnlock()
+
+	now := p.clock()
+	progress := &SwitchProgress{Known: true}
+	infos := map[address.Address]map[uuid.UUID]WorkerInfo{}
+	var percent float64
+	var remaining time.Duration
+	for wid, ws := range out.Worker {
+		done := 0.0
+		switch {
+		case ws.State == StateWorkerComplete || ws.State == StateWorkerStopConfirming:
+			done = 1
+			ws.ETA = &WorkerETA{Known: true}
+		case ws.State == StateWorkerError || out.Req.Type == SwitchTypeAttach || out.Req.Type == SwitchTypeEvacuate:
+			progress.Known = false
+		default:
+			info, ok := infos[ws.From]
+			if !ok {
+				var err error
+				info, err = p.getWorkerInfo(ws.From)
+				if err != nil {
+					log.Warnw("switchProgress getWorkerInfo", "from", ws.From, "err", err)
+				}
+				infos[ws.From] = info
+			}
+			w, ok := info[wid]
+			if !ok {
+				progress.Known = false
+				break
+			}
+			eta := p.estimateWorker(out.Req, ws.From, w)
+			if ws.State >= StateWorkerSwitchConfirming {
+				eta.Switch = 0
+			}
+			ws.ETA = &eta
+			progress.Known = progress.Known && eta.Known
+			remaining = max(remaining, time.Duration(eta.Stop))
+			if ws.Estimate > 0 {
+				done = min(max(1-float64(eta.Stop)/float64(ws.Estimate), 0), 1)
+			}
+		}
+		percent += done
+	}
+
+	if len(out.Worker) != 0 {
+		progress.Percent = percent / float64(len(out.Worker)) * 100
+	}
+	progress.Remaining = config.Duration(remaining)
+	progress.ETA = now.Add(remaining)
+	out.Progress = progress
+	return &out
+}
+
+// trackDurations 确保每个miner的snapshot不超过cacheTimeout并保存任务耗时，耗时在获取snapshot时更新
+func (p *Pilot) trackDurations() {
+	for _, miner := range p.minerList() {
+		if _, err := p.snapshot(miner); err != nil {
+			log.Warnw("trackDurations snapshot", "miner", miner, "err", err)
+		}
+	}
+
+	data, err := p.durations.marshal()
+	if err != nil {
+		log.Errorw("trackDurations marshal", "err", err)
+		return
+	}
+	err = p.repo.WriteDurationState(data)
+	if err != nil {
+		log.Errorw("WriteDurationState", "err", err)
+	}
+}
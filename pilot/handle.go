@@ -2,6 +2,7 @@ package pilot
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/filecoin-project/go-address"
@@ -21,8 +22,15 @@ func (p *Pilot) Handle() {
 	http.HandleFunc("GET /miner/list", middleware.Timer(p.listMinerHandle))
 	http.HandleFunc("GET /miner/worker/{id}", middleware.Timer(p.workerHandle))
 	http.HandleFunc("GET /miner/worker/all", middleware.Timer(p.minerWorkerAllHandle))
+	http.HandleFunc("GET /miner/snapshots", middleware.Timer(p.minerSnapshotsHandle))
+	http.HandleFunc("GET /miner/conns", middleware.Timer(p.minerConnsHandle))
+	http.HandleFunc("GET /miner/durations", middleware.Timer(p.minerDurationsHandle))
+	http.HandleFunc("GET /miner/health", middleware.Timer(p.minerHealthHandle))
+	http.HandleFunc("GET /miner/maintenance/enter/{id}", middleware.Timer(p.enterMaintenanceHandle))
+	http.HandleFunc("GET /miner/maintenance/exit/{id}", middleware.Timer(p.exitMaintenanceHandle))
 
 	http.HandleFunc("POST /switch/new", middleware.Timer(p.switchHandle))
+	http.HandleFunc("POST /switch/pick", middleware.Timer(p.pickHandle))
 	http.HandleFunc("GET /switch/get/{id}", middleware.Timer(p.getSwitchHandle))
 	http.HandleFunc("GET /switch/cancel/{id}", middleware.Timer(p.cancelSwitchHandle))
 	http.HandleFunc("GET /switch/remove/{id}", middleware.Timer(p.removeSwitchHandle))
@@ -30,6 +38,15 @@ func (p *Pilot) Handle() {
 	http.HandleFunc("GET /switch/resume/{id}", middleware.Timer(p.resumeSwitchHandle))
 
 	http.HandleFunc("GET /script/create/{id}", middleware.Timer(p.createScriptHandle))
+
+	http.HandleFunc("GET /autopilot/report", middleware.Timer(p.autopilotReportHandle))
+
+	http.HandleFunc("GET /host/unavailable", middleware.Timer(p.listUnavailableHandle))
+	http.HandleFunc("GET /host/available/{hostname}", middleware.Timer(p.markAvailableHandle))
+
+	http.HandleFunc("POST /worker/pin", middleware.Timer(p.pinHandle))
+	http.HandleFunc("POST /worker/unpin", middleware.Timer(p.unpinHandle))
+	http.HandleFunc("GET /worker/pinned", middleware.Timer(p.listPinnedHandle))
 }
 
 func (p *Pilot) addMinerHandle(w http.ResponseWriter, r *http.Request) {
@@ -49,13 +66,13 @@ func (p *Pilot) addMinerHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mi, err := toMinerInfo(p.ctx, minerAPI.Miner, minerAPI.API)
+	mi, err := toMinerInfo(p.ctx, p.repo, minerAPI.Miner, minerAPI.API)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = p.repo.CreateScript(mi.address, mi.token, mi.size)
+	err = p.repo.CreateScript(mi.address, mi.token, mi.size, p.apiInfoDir)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -136,13 +153,35 @@ func (p *Pilot) workerHandle(w http.ResponseWriter, r *http.Request) {
 }
 
 func (p *Pilot) minerWorkerAllHandle(w http.ResponseWriter, r *http.Request) {
-	out, err := p.minerWorkerAll()
+	out := p.minerWorkerAll()
+
+	body, err := json.Marshal(&out)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.Write(body)
+}
 
-	body, err := json.Marshal(&out)
+func (p *Pilot) switchHandle(w http.ResponseWriter, r *http.Request) {
+	var req SwitchRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ss, err := p.newSwitch(req)
+	if errors.Is(err, ErrKeyConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(ss)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -150,7 +189,7 @@ func (p *Pilot) minerWorkerAllHandle(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
-func (p *Pilot) switchHandle(w http.ResponseWriter, r *http.Request) {
+func (p *Pilot) pickHandle(w http.ResponseWriter, r *http.Request) {
 	var req SwitchRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
@@ -158,7 +197,7 @@ func (p *Pilot) switchHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ss, err := p.newSwitch(req)
+	ss, err := p.previewPick(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -180,7 +219,12 @@ func (p *Pilot) getSwitchHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	body, err := json.Marshal(p.getSwitch(uid))
+	ss := p.getSwitch(uid)
+	if ss != nil {
+		ss = p.switchProgress(ss)
+	}
+
+	body, err := json.Marshal(ss)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -259,3 +303,146 @@ func (p *Pilot) resumeSwitchHandle(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Write(body)
 }
+
+func (p *Pilot) listUnavailableHandle(w http.ResponseWriter, r *http.Request) {
+	hosts := p.listUnavailable()
+
+	body, err := json.Marshal(&hosts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(body)
+}
+
+func (p *Pilot) markAvailableHandle(w http.ResponseWriter, r *http.Request) {
+	hostname := r.PathValue("hostname")
+	err := p.markAvailable(hostname)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (p *Pilot) pinHandle(w http.ResponseWriter, r *http.Request) {
+	var pw PinnedWorker
+	err := json.NewDecoder(r.Body).Decode(&pw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = p.pin(pw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (p *Pilot) unpinHandle(w http.ResponseWriter, r *http.Request) {
+	var pw PinnedWorker
+	err := json.NewDecoder(r.Body).Decode(&pw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = p.unpin(pw.Target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (p *Pilot) listPinnedHandle(w http.ResponseWriter, r *http.Request) {
+	pinned := p.listPinned()
+
+	body, err := json.Marshal(&pinned)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(body)
+}
+
+func (p *Pilot) autopilotReportHandle(w http.ResponseWriter, r *http.Request) {
+	report, err := p.autopilotReport()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(&report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(body)
+}
+
+func (p *Pilot) minerHealthHandle(w http.ResponseWriter, r *http.Request) {
+	health := p.listHealth()
+
+	body, err := json.Marshal(&health)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(body)
+}
+
+func (p *Pilot) minerSnapshotsHandle(w http.ResponseWriter, r *http.Request) {
+	status := p.collector.status(p.clock())
+
+	body, err := json.Marshal(&status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(body)
+}
+
+func (p *Pilot) minerConnsHandle(w http.ResponseWriter, r *http.Request) {
+	status := p.conns.list()
+
+	body, err := json.Marshal(&status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(body)
+}
+
+func (p *Pilot) minerDurationsHandle(w http.ResponseWriter, r *http.Request) {
+	durations := p.durations.list()
+
+	body, err := json.Marshal(&durations)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(body)
+}
+
+func (p *Pilot) enterMaintenanceHandle(w http.ResponseWriter, r *http.Request) {
+	p.maintenanceHandle(w, r, true)
+}
+
+func (p *Pilot) exitMaintenanceHandle(w http.ResponseWriter, r *http.Request) {
+	p.maintenanceHandle(w, r, false)
+}
+
+func (p *Pilot) maintenanceHandle(w http.ResponseWriter, r *http.Request, maintenance bool) {
+	id := r.PathValue("id")
+	maddr, err := address.NewFromString(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = p.setMaintenance(maddr, maintenance)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,196 @@
+package pilot
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
+)
+
+var sectorSizes = []abi.SectorSize{2 << 10, 8 << 20, 512 << 20, 32 << 30, 64 << 30}
+
+// parseHardware 检查config中的sector大小，key为 32GiB、64GiB
+func parseHardware(conf map[string]config.Hardware) (map[abi.SectorSize]config.Hardware, error) {
+	out := map[abi.SectorSize]config.Hardware{}
+	for key, hw := range conf {
+		found := false
+		for _, size := range sectorSizes {
+			if size.ShortString() == key {
+				out[size] = hw
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("hardware unknown sector size: %s", key)
+		}
+	}
+	return out, nil
+}
+
+// sealPaths 返回worker所有可以封存的路径
+func sealPaths(st storiface.WorkerStats) []storiface.ID {
+	var out []storiface.ID
+	for _, p := range st.Paths {
+		if p.CanSeal {
+			out = append(out, p.ID)
+		}
+	}
+	return out
+}
+
+// SealPath worker封存路径上的sector和容量，获取容量失败时Err不为空
+type SealPath struct {
+	ID        storiface.ID `json:"id"`
+	Sectors   []string     `json:"sectors"`
+	Capacity  int64        `json:"capacity"`
+	Available int64        `json:"available"`
+	Err       string       `json:"err,omitempty"`
+}
+
+// pathSectors 返回路径上的sectorID，同一个sector的多个文件只记录一次
+func pathSectors(decls []storiface.Decl) []string {
+	numbers := map[abi.SectorNumber]struct{}{}
+	for _, d := range decls {
+		numbers[d.SectorID.Number] = struct{}{}
+	}
+	sorted := make([]abi.SectorNumber, 0, len(numbers))
+	for n := range numbers {
+		sorted = append(sorted, n)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	out := make([]string, 0, len(sorted))
+	for _, n := range sorted {
+		out = append(out, n.String())
+	}
+	return out
+}
+
+// statParallel 同时获取封存路径容量的最大请求数
+const statParallel = 16
+
+// statSealPaths 并发获取所有worker封存路径的容量，每个请求单独超时，单个路径失败不影响worker数据
+func (p *Pilot) statSealPaths(api v0api.StorageMiner, wst wst) map[storiface.ID]SealPath {
+	var ids []storiface.ID
+	seen := map[storiface.ID]struct{}{}
+	for _, st := range wst {
+		for _, id := range sealPaths(st) {
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+
+	var lk sync.Mutex
+	var wg sync.WaitGroup
+	throttle := make(chan struct{}, statParallel)
+	out := map[storiface.ID]SealPath{}
+	for _, id := range ids {
+		wg.Add(1)
+		throttle <- struct{}{}
+		go func(id storiface.ID) {
+			defer wg.Done()
+			defer func() {
+				<-throttle
+			}()
+
+			ctx, cancel := p.rpcContext()
+			defer cancel()
+
+			path := SealPath{ID: id}
+			fs, err := api.StorageStat(ctx, id)
+			if err != nil {
+				log.Debugw("StorageStat", "path", id, "err", err)
+				path.Err = err.Error()
+			} else {
+				path.Capacity = fs.Capacity
+				path.Available = fs.Available
+			}
+
+			lk.Lock()
+			out[id] = path
+			lk.Unlock()
+		}(id)
+	}
+	wg.Wait()
+	return out
+}
+
+// checkHardware 检查worker的资源是否满足to的sector大小需要的最低配置
+func (p *Pilot) checkHardware(req SwitchRequest, from address.Address, w WorkerInfo) error {
+	if req.Type != SwitchTypeSwitch {
+		return nil
+	}
+
+	size := p.minerSectorSize(req.To)
+	hw, ok := p.hardware[size]
+	if !ok {
+		return nil
+	}
+
+	res := w.Resources
+	if hw.MemGiB != 0 && res.MemPhysical < uint64(hw.MemGiB)<<30 {
+		return fmt.Errorf("memory %dGiB less than %dGiB required by %s", res.MemPhysical>>30, hw.MemGiB, size.ShortString())
+	}
+	if hw.CPUs != 0 && res.CPUs < uint64(hw.CPUs) {
+		return fmt.Errorf("cpus %d less than %d required by %s", res.CPUs, hw.CPUs, size.ShortString())
+	}
+	if hw.GPUs != 0 && len(res.GPUs) < hw.GPUs {
+		return fmt.Errorf("gpus %d less than %d required by %s", len(res.GPUs), hw.GPUs, size.ShortString())
+	}
+	if hw.SealGiB != 0 {
+		capacity, err := p.workerSealCapacity(from, w)
+		if err != nil {
+			//获取容量失败不能说明worker不满足要求，只检查其他配置
+			log.Warnw("skip seal capacity check", "hostname", w.Hostname, "err", err)
+			return nil
+		}
+		if capacity < int64(hw.SealGiB)<<30 {
+			return fmt.Errorf("seal capacity %dGiB less than %dGiB required by %s", capacity>>30, hw.SealGiB, size.ShortString())
+		}
+	}
+	return nil
+}
+
+// workerSealCapacity 优先使用snapshot中记录的封存路径容量，snapshot中有路径获取失败时重新获取
+func (p *Pilot) workerSealCapacity(ma address.Address, w WorkerInfo) (int64, error) {
+	var capacity int64
+	for _, path := range w.Paths {
+		if path.Err != "" {
+			return p.sealCapacity(ma, w.SealPaths)
+		}
+		capacity += path.Capacity
+	}
+	if len(w.Paths) == 0 {
+		return p.sealCapacity(ma, w.SealPaths)
+	}
+	return capacity, nil
+}
+
+// sealCapacity 返回封存路径的总容量
+func (p *Pilot) sealCapacity(ma address.Address, paths []storiface.ID) (int64, error) {
+	api, err := p.minerAPI(ma)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := p.rpcContext()
+	defer cancel()
+
+	var capacity int64
+	for _, id := range paths {
+		st, err := api.StorageStat(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		capacity += st.Capacity
+	}
+	return capacity, nil
+}
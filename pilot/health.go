@@ -0,0 +1,452 @@
+package pilot
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
+	"github.com/google/uuid"
+)
+
+const (
+	SourceEvacuation = "evacuation"
+	SourceSwitchBack = "switchBack"
+)
+
+const defaultMaxFailures = 3
+
+// MinerHealth miner的健康状态，连续RPC失败或者处于维护状态时miner不健康
+type MinerHealth struct {
+	Failures int       `json:"failures"`
+	LastErr  string    `json:"lastErr"`
+	LastOK   time.Time `json:"lastOK"`
+	//不健康的开始时间，健康时为零值
+	UnhealthySince time.Time `json:"unhealthySince"`
+	Maintenance    bool      `json:"maintenance"`
+	//最近一次成功获取的worker，miner不可用时根据它撤离
+	Workers map[uuid.UUID]string `json:"workers"`
+	//Workers中每个worker的角色，撤离时按请求的角色选择
+	Roles map[uuid.UUID][]WorkerRole `json:"roles"`
+	//撤离的switch，切回或者不需要切回后清空
+	Evacuation uuid.UUID `json:"evacuation"`
+}
+
+func (h *MinerHealth) Healthy() bool {
+	return h.UnhealthySince.IsZero()
+}
+
+type evacuation struct {
+	conf     config.Evacuation
+	grace    time.Duration
+	fallback map[address.Address]address.Address
+
+	lk sync.Mutex
+	//key为miner地址，go-address不支持作为json的map key
+	health map[string]*MinerHealth
+}
+
+func newEvacuation(conf config.Evacuation, data []byte) (*evacuation, error) {
+	ev := &evacuation{
+		conf:     conf,
+		grace:    time.Duration(conf.Grace),
+		fallback: map[address.Address]address.Address{},
+	}
+	if ev.conf.MaxFailures <= 0 {
+		ev.conf.MaxFailures = defaultMaxFailures
+	}
+
+	for from, to := range conf.Fallback {
+		fromAddr, err := address.NewFromString(from)
+		if err != nil {
+			return nil, err
+		}
+		toAddr, err := address.NewFromString(to)
+		if err != nil {
+			return nil, err
+		}
+		if fromAddr == toAddr {
+			return nil, fmt.Errorf("evacuation fallback of miner: %s is itself", from)
+		}
+		ev.fallback[fromAddr] = toAddr
+	}
+
+	err := json.Unmarshal(data, &ev.health)
+	if err != nil {
+		return nil, err
+	}
+	if ev.health == nil {
+		ev.health = map[string]*MinerHealth{}
+	}
+
+	return ev, nil
+}
+
+// recordHealth 记录一次miner RPC的结果，st为成功时获取的WorkerStats
+func (p *Pilot) recordHealth(ma address.Address, st wst, err error) {
+	ev := p.evac
+	ev.lk.Lock()
+	defer ev.lk.Unlock()
+
+	h, ok := ev.health[ma.String()]
+	if !ok {
+		h = &MinerHealth{}
+		ev.health[ma.String()] = h
+	}
+
+	needWrite := false
+	if err != nil {
+		h.Failures += 1
+		h.LastErr = err.Error()
+		if h.Healthy() && h.Failures >= ev.conf.MaxFailures {
+			h.UnhealthySince = p.clock()
+			log.Warnw("miner unhealthy", "miner", ma, "failures", h.Failures, "err", err)
+			needWrite = true
+		}
+	} else {
+		h.Failures = 0
+		h.LastErr = ""
+		h.LastOK = p.clock()
+		if !h.Healthy() && !h.Maintenance {
+			h.UnhealthySince = time.Time{}
+			log.Infow("miner recovered", "miner", ma)
+			needWrite = true
+		}
+
+		if st != nil {
+			workers := map[uuid.UUID]string{}
+			roles := map[uuid.UUID][]WorkerRole{}
+			for wid, w := range st {
+				if workerCheck(w) {
+					workers[wid] = w.Info.Hostname
+					roles[wid] = workerRoles(w)
+				}
+			}
+			if !sameWorkers(h.Workers, workers) || !reflect.DeepEqual(h.Roles, roles) {
+				h.Workers = workers
+				h.Roles = roles
+				needWrite = true
+			}
+		}
+	}
+
+	if !needWrite {
+		return
+	}
+	if err := p.writeHealth(); err != nil {
+		log.Errorw("writeHealth", "err", err)
+	}
+}
+
+func sameWorkers(a, b map[uuid.UUID]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for wid, hostname := range a {
+		if b[wid] != hostname {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Pilot) isHealthy(ma address.Address) bool {
+	p.evac.lk.Lock()
+	defer p.evac.lk.Unlock()
+
+	h, ok := p.evac.health[ma.String()]
+	if !ok {
+		return true
+	}
+	return h.Healthy()
+}
+
+// isReachable miner的RPC是否可用，维护中但RPC正常的miner可以使用
+func (p *Pilot) isReachable(ma address.Address) bool {
+	p.evac.lk.Lock()
+	defer p.evac.lk.Unlock()
+
+	h, ok := p.evac.health[ma.String()]
+	if !ok {
+		return true
+	}
+	return h.Failures < p.evac.conf.MaxFailures
+}
+
+func (p *Pilot) minerHealth(ma address.Address) MinerHealth {
+	p.evac.lk.Lock()
+	defer p.evac.lk.Unlock()
+
+	h, ok := p.evac.health[ma.String()]
+	if !ok {
+		return MinerHealth{}
+	}
+	return *h
+}
+
+func (p *Pilot) listHealth() map[string]MinerHealth {
+	p.evac.lk.Lock()
+	defer p.evac.lk.Unlock()
+
+	out := map[string]MinerHealth{}
+	for ma, h := range p.evac.health {
+		out[ma] = *h
+	}
+	return out
+}
+
+// setMaintenance 维护中的miner立即变为不健康，退出维护后等待下一次RPC成功再恢复
+func (p *Pilot) setMaintenance(ma address.Address, maintenance bool) error {
+	if !p.hasMiner(ma) {
+		return fmt.Errorf("not found miner: %s", ma)
+	}
+
+	p.evac.lk.Lock()
+	defer p.evac.lk.Unlock()
+
+	h, ok := p.evac.health[ma.String()]
+	if !ok {
+		h = &MinerHealth{}
+		p.evac.health[ma.String()] = h
+	}
+	h.Maintenance = maintenance
+	if maintenance && h.Healthy() {
+		h.UnhealthySince = p.clock()
+	}
+	log.Infow("miner maintenance", "miner", ma, "maintenance", maintenance)
+
+	return p.writeHealth()
+}
+
+func (p *Pilot) setEvacuation(ma address.Address, id uuid.UUID) {
+	p.evac.lk.Lock()
+	defer p.evac.lk.Unlock()
+
+	h, ok := p.evac.health[ma.String()]
+	if !ok {
+		h = &MinerHealth{}
+		p.evac.health[ma.String()] = h
+	}
+	h.Evacuation = id
+
+	err := p.writeHealth()
+	if err != nil {
+		log.Errorw("writeHealth", "err", err)
+	}
+}
+
+// write health state to repo/state
+// caller need keep evac.lk lock
+func (p *Pilot) writeHealth() error {
+	data, err := json.Marshal(p.evac.health)
+	if err != nil {
+		return err
+	}
+
+	return p.repo.WriteHealthState(data)
+}
+
+// runEvacuation 定期探测所有miner，不健康超过grace的miner撤离到备用miner
+func (p *Pilot) runEvacuation() {
+	if !p.evac.conf.Enable {
+		return
+	}
+
+	go func() {
+		t := time.NewTicker(p.interval)
+		for {
+			select {
+			case <-t.C:
+				for _, miner := range p.minerList() {
+					//错误已经由recordHealth记录
+					_, _ = p.workerStats(miner)
+				}
+				p.evacuate()
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (p *Pilot) evacuate() {
+	for from, to := range p.evac.fallback {
+		if !p.hasMiner(from) {
+			continue
+		}
+
+		h := p.minerHealth(from)
+		if !h.Healthy() {
+			if h.Evacuation != uuid.Nil {
+				continue
+			}
+			if p.clock().Sub(h.UnhealthySince) < p.evac.grace {
+				continue
+			}
+			if !p.hasMiner(to) || !p.isHealthy(to) {
+				log.Warnw("evacuation fallback unavailable", "miner", from, "fallback", to)
+				continue
+			}
+
+			ss, err := p.newSwitch(SwitchRequest{
+				Type:   SwitchTypeEvacuate,
+				From:   from,
+				To:     to,
+				Source: SourceEvacuation,
+			})
+			if err != nil {
+				log.Errorw("evacuation", "miner", from, "fallback", to, "err", err)
+				continue
+			}
+			log.Infow("evacuation", "miner", from, "fallback", to, "switchID", ss.ID, "worker", len(ss.Worker))
+			p.setEvacuation(from, ss.ID)
+			continue
+		}
+
+		if h.Evacuation == uuid.Nil {
+			continue
+		}
+		if !p.evac.conf.SwitchBack {
+			log.Infow("miner recovered, switch back disabled", "miner", from, "evacuation", h.Evacuation)
+			p.setEvacuation(from, uuid.Nil)
+			continue
+		}
+		p.switchBack(from, to, h.Evacuation)
+	}
+}
+
+// switchBack 把撤离完成的worker从fallback切回恢复的miner
+func (p *Pilot) switchBack(miner, fallback address.Address, id uuid.UUID) {
+	ss := p.getSwitch(id)
+	if ss == nil {
+		p.setEvacuation(miner, uuid.Nil)
+		return
+	}
+
+	p.swLk.RLock()
+	state := ss.State
+	var hostnames []string
+	for _, ws := range ss.Worker {
+		if ws.State == StateWorkerComplete {
+			hostnames = append(hostnames, ws.Hostname)
+		}
+	}
+	p.swLk.RUnlock()
+
+	if state == StateSwitching {
+		//等待撤离完成后再切回
+		return
+	}
+	if len(hostnames) == 0 {
+		p.setEvacuation(miner, uuid.Nil)
+		return
+	}
+
+	st, err := p.workerStats(fallback)
+	if err != nil {
+		log.Errorw("switchBack workerStats", "fallback", fallback, "err", err)
+		return
+	}
+	sort.Strings(hostnames)
+	var worker []uuid.UUID
+	for _, hostname := range hostnames {
+		wid, ok := workerByHostname(st, hostname)
+		if !ok {
+			log.Warnw("switchBack worker not found in fallback", "hostname", hostname, "fallback", fallback)
+			continue
+		}
+		worker = append(worker, wid)
+	}
+
+	if len(worker) != 0 {
+		back, err := p.newSwitch(SwitchRequest{
+			Type:   SwitchTypeSwitch,
+			From:   fallback,
+			To:     miner,
+			Worker: worker,
+			Role:   ss.Req.Role,
+			Source: SourceSwitchBack,
+		})
+		if err != nil {
+			log.Errorw("switchBack", "miner", miner, "fallback", fallback, "err", err)
+			return
+		}
+		log.Infow("switchBack", "miner", miner, "fallback", fallback, "switchID", back.ID, "worker", len(back.Worker))
+	}
+	p.setEvacuation(miner, uuid.Nil)
+}
+
+// evacuatePick 根据最近一次获取的worker选择要撤离的worker，撤离时from的RPC不可用
+func (p *Pilot) evacuatePick(req SwitchRequest) (map[uuid.UUID]*WorkerState, error) {
+	if !p.hasMiner(req.To) {
+		return nil, fmt.Errorf("not found miner: %s", req.To)
+	}
+
+	h := p.minerHealth(req.From)
+	known := h.Workers
+	switchingWorkers := p.switchingWorkers()
+
+	pick := map[uuid.UUID]string{}
+	if len(req.Worker) != 0 {
+		for _, wid := range req.Worker {
+			hostname, ok := known[wid]
+			if !ok {
+				return nil, fmt.Errorf("specify worker: %s not known in miner: %s", wid, req.From)
+			}
+			if !containsRole(h.Roles[wid], req.Role) {
+				return nil, fmt.Errorf("specify worker: %s illegal for role: %s", wid, req.Role)
+			}
+			if _, ok := switchingWorkers[wid]; ok {
+				return nil, fmt.Errorf("specify worker: %s already switching", wid)
+			}
+			if !req.Force && p.isPinned(wid, hostname) {
+				return nil, fmt.Errorf("specify worker: %s host: %s pinned, use force to switch", wid, hostname)
+			}
+			pick[wid] = hostname
+		}
+	} else {
+		for wid, hostname := range known {
+			if !containsRole(h.Roles[wid], req.Role) {
+				continue
+			}
+			if _, ok := switchingWorkers[wid]; ok {
+				continue
+			}
+			if p.isUnavailable(hostname) {
+				continue
+			}
+			if p.isPinned(wid, hostname) {
+				continue
+			}
+			pick[wid] = hostname
+		}
+	}
+
+	if len(pick) == 0 {
+		return nil, fmt.Errorf("no worker can be evacuated from miner: %s", req.From)
+	}
+
+	out := map[uuid.UUID]*WorkerState{}
+	for wid, hostname := range pick {
+		out[wid] = &WorkerState{
+			WorkerID: wid,
+			Hostname: hostname,
+			From:     req.From,
+			State:    StateWorkerPicked,
+		}
+	}
+	return out, nil
+}
+
+func containsRole(roles []WorkerRole, role WorkerRole) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
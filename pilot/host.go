@@ -0,0 +1,93 @@
+package pilot
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UnavailableHost drain完成后被标记为不可用的机器，pilot不会再选择它
+type UnavailableHost struct {
+	Hostname string    `json:"hostname"`
+	SwitchID uuid.UUID `json:"switchID"`
+	Time     time.Time `json:"time"`
+}
+
+func (p *Pilot) markUnavailable(hostname string, switchID uuid.UUID) error {
+	p.hostLk.Lock()
+	defer p.hostLk.Unlock()
+
+	p.unavailable[hostname] = UnavailableHost{
+		Hostname: hostname,
+		SwitchID: switchID,
+		Time:     p.clock(),
+	}
+	log.Infow("mark host unavailable", "hostname", hostname, "switchID", switchID)
+
+	return p.writeHost()
+}
+
+func (p *Pilot) markAvailable(hostname string) error {
+	p.hostLk.Lock()
+	defer p.hostLk.Unlock()
+
+	if _, ok := p.unavailable[hostname]; !ok {
+		return fmt.Errorf("host: %s not unavailable", hostname)
+	}
+
+	delete(p.unavailable, hostname)
+	log.Infow("mark host available", "hostname", hostname)
+
+	return p.writeHost()
+}
+
+// markAttached attach成功的机器重新变为可用
+func (p *Pilot) markAttached(hostname string) {
+	p.hostLk.Lock()
+	defer p.hostLk.Unlock()
+
+	if _, ok := p.unavailable[hostname]; !ok {
+		return
+	}
+
+	delete(p.unavailable, hostname)
+	log.Infow("attached host available", "hostname", hostname)
+
+	err := p.writeHost()
+	if err != nil {
+		log.Errorw("writeHost", "err", err)
+	}
+}
+
+func (p *Pilot) isUnavailable(hostname string) bool {
+	p.hostLk.RLock()
+	defer p.hostLk.RUnlock()
+
+	_, ok := p.unavailable[hostname]
+	return ok
+}
+
+func (p *Pilot) listUnavailable() []UnavailableHost {
+	p.hostLk.RLock()
+	defer p.hostLk.RUnlock()
+
+	var out []UnavailableHost
+	for _, h := range p.unavailable {
+		out = append(out, h)
+	}
+
+	return out
+}
+
+// write host state to repo/state
+// caller need keep hostLk lock
+func (p *Pilot) writeHost() error {
+	data, err := json.Marshal(p.unavailable)
+	if err != nil {
+		return err
+	}
+
+	return p.repo.WriteHostState(data)
+}
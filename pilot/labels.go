@@ -0,0 +1,77 @@
+package pilot
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gh-efforts/lotus-pilot/repo/config"
+)
+
+// inventory 机器标签，按配置顺序匹配hostname，后面的标签覆盖前面的
+type inventory []config.HostLabels
+
+func newInventory(hosts []config.HostLabels) (inventory, error) {
+	for _, h := range hosts {
+		if h.Hostname == "" {
+			return nil, fmt.Errorf("host labels hostname is empty: %v", h.Labels)
+		}
+		if _, err := path.Match(h.Hostname, ""); err != nil {
+			return nil, fmt.Errorf("host labels pattern: %s %w", h.Hostname, err)
+		}
+		for k := range h.Labels {
+			if k == "" || strings.ContainsAny(k, "=,") {
+				return nil, fmt.Errorf("host: %s illegal label key: %s", h.Hostname, k)
+			}
+		}
+	}
+	return inventory(hosts), nil
+}
+
+func (inv inventory) labels(hostname string) map[string]string {
+	out := map[string]string{}
+	for _, h := range inv {
+		if ok, _ := path.Match(h.Hostname, hostname); !ok {
+			continue
+		}
+		for k, v := range h.Labels {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// parseSelector 解析标签选择器，例如 rack=L06,gpu=3090
+func parseSelector(s string) (map[string]string, error) {
+	sel := map[string]string{}
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("illegal label selector: %s", s)
+		}
+		sel[k] = v
+	}
+	return sel, nil
+}
+
+// matchSelector 所有选择的标签都相同时匹配
+func matchSelector(sel, labels map[string]string) bool {
+	for k, v := range sel {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// hostMatch 机器的标签是否匹配选择器，选择器已经在check中检查过
+func (p *Pilot) hostMatch(selector, hostname string) bool {
+	sel, err := parseSelector(selector)
+	if err != nil {
+		return false
+	}
+	return matchSelector(sel, p.inventory.labels(hostname))
+}
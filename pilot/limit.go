@@ -0,0 +1,131 @@
+package pilot
+
+import (
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/google/uuid"
+)
+
+// minerLimits 返回miner配置的minWorkers和maxWorkers
+func (p *Pilot) minerLimits(ma address.Address) (int, int) {
+	p.lk.RLock()
+	defer p.lk.RUnlock()
+
+	mi := p.miners[ma]
+	return mi.minWorkers, mi.maxWorkers
+}
+
+// inflightWorkers 进行中的切换里还没有离开from的worker数量，和还没有到达to的worker数量
+func (p *Pilot) inflightWorkers() (map[address.Address]int, map[address.Address]int) {
+	p.swLk.RLock()
+	defer p.swLk.RUnlock()
+
+	outgoing := map[address.Address]int{}
+	incoming := map[address.Address]int{}
+	for _, ss := range p.switchs {
+		if ss.State != StateSwitching {
+			continue
+		}
+		for _, ws := range ss.Worker {
+			if ws.State == StateWorkerComplete || ws.State == StateWorkerError {
+				continue
+			}
+			if !ws.From.Empty() {
+				outgoing[ws.From] += 1
+			}
+			if !ss.Req.To.Empty() && ws.State <= StateWorkerSwitchConfirming {
+				incoming[ss.Req.To] += 1
+			}
+		}
+	}
+
+	return outgoing, incoming
+}
+
+// minerWorkerCount 返回miner上pilot管理的worker数量
+func (p *Pilot) minerWorkerCount(ma address.Address) (int, error) {
+	st, err := p.workerStats(ma)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, w := range st {
+		if workerCheck(w) {
+			count += 1
+		}
+	}
+	return count, nil
+}
+
+// checkLimits 检查切换后from不低于minWorkers，to不超过maxWorkers，req.Override为true时不检查
+func (p *Pilot) checkLimits(req SwitchRequest, worker map[uuid.UUID]*WorkerState) error {
+	if req.Override {
+		return nil
+	}
+
+	outgoing, incoming := p.inflightWorkers()
+
+	picked := map[address.Address]int{}
+	for _, ws := range worker {
+		if !ws.From.Empty() {
+			picked[ws.From] += 1
+		}
+	}
+	//evacuate的from不可用，不检查minWorkers
+	if req.Type != SwitchTypeEvacuate {
+		for from, n := range picked {
+			minWorkers, _ := p.minerLimits(from)
+			if minWorkers == 0 {
+				continue
+			}
+			count, err := p.minerWorkerCount(from)
+			if err != nil {
+				return err
+			}
+			remain := count - outgoing[from] - n
+			if remain < minWorkers {
+				return fmt.Errorf("miner: %s minWorkers: %d violated: has %d workers, %d switching out, %d picked, %d would remain. use override to ignore",
+					from, minWorkers, count, outgoing[from], n, remain)
+			}
+		}
+	}
+
+	if req.To.Empty() {
+		return nil
+	}
+	_, maxWorkers := p.minerLimits(req.To)
+	if maxWorkers == 0 {
+		return nil
+	}
+	count, err := p.minerWorkerCount(req.To)
+	if err != nil {
+		return err
+	}
+	total := count + incoming[req.To] + len(worker)
+	if total > maxWorkers {
+		return fmt.Errorf("miner: %s maxWorkers: %d violated: has %d workers, %d switching in, %d picked, %d would be. use override to ignore",
+			req.To, maxWorkers, count, incoming[req.To], len(worker), total)
+	}
+
+	return nil
+}
+
+// clampLimits 自动切换的期望worker数量限制在miner的minWorkers和maxWorkers之间
+func (p *Pilot) clampLimits(desired map[address.Address]int, ev map[string]MinerEvidence) {
+	for miner, d := range desired {
+		minWorkers, maxWorkers := p.minerLimits(miner)
+		c := max(d, minWorkers)
+		if maxWorkers != 0 {
+			c = min(c, maxWorkers)
+		}
+		if c == d {
+			continue
+		}
+		desired[miner] = c
+		e := ev[miner.String()]
+		e.Desired = c
+		ev[miner.String()] = e
+	}
+}
@@ -0,0 +1,37 @@
+package pilot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+)
+
+func TestClampLimits(t *testing.T) {
+	m := testMiners(t, 4)
+	a, b, c, d := m[0], m[1], m[2], m[3]
+
+	p := &Pilot{
+		miners: map[address.Address]MinerInfo{
+			a: {address: a, minWorkers: 3},
+			b: {address: b, maxWorkers: 4},
+			c: {address: c, minWorkers: 2, maxWorkers: 5},
+			d: {address: d},
+		},
+	}
+
+	desired := map[address.Address]int{a: 1, b: 6, c: 3, d: 7}
+	ev := map[string]MinerEvidence{}
+	p.clampLimits(desired, ev)
+
+	expect := map[address.Address]int{a: 3, b: 4, c: 3, d: 7}
+	if !reflect.DeepEqual(desired, expect) {
+		t.Fatalf("got: %v expect: %v", desired, expect)
+	}
+	if ev[a.String()].Desired != 3 || ev[b.String()].Desired != 4 {
+		t.Fatalf("evidence not updated: %+v", ev)
+	}
+	if _, ok := ev[c.String()]; ok {
+		t.Fatalf("evidence of unclamped miner changed: %+v", ev[c.String()])
+	}
+}
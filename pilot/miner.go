@@ -1,10 +1,13 @@
 package pilot
 
 import (
+	"sync"
+
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
 )
 
 type MinerInfo struct {
@@ -13,6 +16,12 @@ type MinerInfo struct {
 	address address.Address
 	size    abi.SectorSize
 	token   string
+	//重连时使用
+	info config.APIInfo
+	url  string
+	//worker数量限制
+	minWorkers int
+	maxWorkers int
 }
 
 func (p *Pilot) addMiner(mi MinerInfo) {
@@ -20,19 +29,25 @@ func (p *Pilot) addMiner(mi MinerInfo) {
 	defer p.lk.Unlock()
 
 	p.miners[mi.address] = mi
+	p.conns.add(mi.address, mi.url, p.clock())
 	log.Infof("add miner: %s", mi.address)
 }
 
 func (p *Pilot) removeMiner(ma address.Address) {
 	p.lk.Lock()
-	defer p.lk.Unlock()
+	closer := p.miners[ma].closer
+	delete(p.miners, ma)
+	p.lk.Unlock()
 
-	if c := p.miners[ma].closer; c != nil {
+	if closer != nil {
 		log.Infow("remove closed miner api", "miner", ma)
-		c()
+		closer()
 	}
 
-	delete(p.miners, ma)
+	//先从p.miners删除再清除缓存，获取中的snapshot不会再被保存
+	//collector.fetch持有collector的锁时会获取p.lk，这里不能持有p.lk
+	p.collector.remove(ma)
+	p.conns.remove(ma)
 	log.Infof("remove miner: %s", ma)
 }
 
@@ -48,27 +63,60 @@ func (p *Pilot) listMiner() []string {
 	return miners
 }
 
-func (p *Pilot) hasMiner(ma address.Address) bool {
+func (p *Pilot) minerList() []address.Address {
 	p.lk.RLock()
 	defer p.lk.RUnlock()
 
-	_, ok := p.miners[ma]
-	return ok
+	var miners []address.Address
+	for miner := range p.miners {
+		miners = append(miners, miner)
+	}
+
+	return miners
 }
 
-func (p *Pilot) minerWorkerAll() (map[string]int, error) {
+func (p *Pilot) hasMiner(ma address.Address) bool {
 	p.lk.RLock()
 	defer p.lk.RUnlock()
 
-	out := map[string]int{}
-	for miner := range p.miners {
-		st, err := p.getWorkerStats(miner)
-		if err != nil {
-			return out, err
-		}
+	_, ok := p.miners[ma]
+	return ok
+}
+
+// MinerWorkers miner上有封存角色的worker数量，获取失败时Err不为空
+type MinerWorkers struct {
+	Workers int    `json:"workers"`
+	Err     string `json:"err,omitempty"`
+}
 
-		out[miner.String()] = len(st)
+// minerWorkerAll 并发获取所有miner，不持有p.lk，单个miner失败不影响其他miner
+func (p *Pilot) minerWorkerAll() map[string]MinerWorkers {
+	var lk sync.Mutex
+	var wg sync.WaitGroup
+	out := map[string]MinerWorkers{}
+	for _, miner := range p.minerList() {
+		wg.Add(1)
+		go func(ma address.Address) {
+			defer wg.Done()
+
+			var mw MinerWorkers
+			st, err := p.getWorkerStats(ma)
+			if err != nil {
+				mw.Err = err.Error()
+			}
+			//只统计有封存角色的worker
+			for _, w := range st {
+				if len(workerRoles(w)) != 0 {
+					mw.Workers += 1
+				}
+			}
+
+			lk.Lock()
+			out[ma.String()] = mw
+			lk.Unlock()
+		}(miner)
 	}
+	wg.Wait()
 
-	return out, nil
+	return out
 }
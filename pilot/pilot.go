@@ -2,14 +2,20 @@ package pilot
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/lotus/api/client"
+	"github.com/filecoin-project/lotus/api/v0api"
 	"github.com/gh-efforts/lotus-pilot/repo"
 	"github.com/gh-efforts/lotus-pilot/repo/config"
 	"github.com/google/uuid"
@@ -22,22 +28,41 @@ type Pilot struct {
 	ctx          context.Context
 	interval     time.Duration
 	cacheTimeout time.Duration
+	rpcTimeout   time.Duration
+	//worker机器上保存miner API信息的目录
+	apiInfoDir string
 
-	lk     sync.RWMutex
-	miners map[address.Address]MinerInfo
+	lk       sync.RWMutex
+	miners   map[address.Address]MinerInfo
+	policies map[address.Address]config.Policy
 
 	swLk    sync.RWMutex
 	switchs map[uuid.UUID]*SwitchState
+	//newLk 保证相同key的请求不会并发创建switch
+	newLk sync.Mutex
 
-	repo *repo.Repo
+	hostLk      sync.RWMutex
+	unavailable map[string]UnavailableHost
+	//key为PinnedWorker.Target
+	pinned    map[string]PinnedWorker
+	inventory inventory
+	//每个sector大小需要的worker最低配置
+	hardware map[abi.SectorSize]config.Hardware
 
-	icLk      sync.Mutex
-	infoCache map[address.Address]workerInfoCache
+	repo *repo.Repo
 
-	scLk       sync.Mutex
-	statsCache map[address.Address]workerStatsCache
+	collector *collector
+	conns     *connManager
 
 	parallel int
+	exec     executor
+	clock    func() time.Time
+
+	ap    *autopilot
+	quota *quotaTracker
+	evac  *evacuation
+	//任务耗时，用于估算切换时间
+	durations *durationTracker
 }
 
 func NewPilot(ctx context.Context, r *repo.Repo) (*Pilot, error) {
@@ -48,19 +73,24 @@ func NewPilot(ctx context.Context, r *repo.Repo) (*Pilot, error) {
 
 	miners := map[address.Address]MinerInfo{}
 	for miner, info := range conf.Miners {
-		mi, err := toMinerInfo(ctx, miner, info)
+		mi, err := toMinerInfo(ctx, r, miner, info)
 		if err != nil {
 			return nil, err
 		}
 
 		miners[mi.address] = mi
 
-		err = r.CreateScript(mi.address, info.ToAPIInfo(), mi.size)
+		err = r.CreateScript(mi.address, mi.token, mi.size, conf.APIInfoDir)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	policies, err := parsePolicies(conf.Policies)
+	if err != nil {
+		return nil, err
+	}
+
 	data, err := r.ReadSwitchState()
 	if err != nil {
 		return nil, err
@@ -70,19 +100,121 @@ func NewPilot(ctx context.Context, r *repo.Repo) (*Pilot, error) {
 	if err != nil {
 		return nil, err
 	}
+	for _, ss := range switchs {
+		for _, ws := range ss.Worker {
+			//兼容旧版本的switch state，worker没有记录From
+			if ws.From.Empty() {
+				ws.From = ss.Req.From
+			}
+		}
+		//兼容旧版本的switch state，没有记录Role
+		if ss.Req.Role == "" && ss.Req.Type != SwitchTypeAttach {
+			ss.Req.Role = RoleSealing
+		}
+	}
+
+	data, err = r.ReadHostState()
+	if err != nil {
+		return nil, err
+	}
+	var unavailable map[string]UnavailableHost
+	err = json.Unmarshal(data, &unavailable)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = r.ReadPinState()
+	if err != nil {
+		return nil, err
+	}
+	var pinned map[string]PinnedWorker
+	err = json.Unmarshal(data, &pinned)
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := newInventory(conf.Hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	hardware, err := parseHardware(conf.Hardware)
+	if err != nil {
+		return nil, err
+	}
+
+	ap, err := newAutopilot(conf.Autopilot)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = r.ReadQuotaState()
+	if err != nil {
+		return nil, err
+	}
+	quota, err := loadQuotaTracker(data)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = r.ReadHealthState()
+	if err != nil {
+		return nil, err
+	}
+	evac, err := newEvacuation(conf.Evacuation, data)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = r.ReadDurationState()
+	if err != nil {
+		return nil, err
+	}
+	durations, err := loadDurationTracker(data)
+	if err != nil {
+		return nil, err
+	}
 
 	p := &Pilot{
 		ctx:          ctx,
 		interval:     time.Duration(conf.Interval),
 		cacheTimeout: time.Duration(conf.CacheTimeout),
+		rpcTimeout:   time.Duration(conf.RPCTimeout),
+		apiInfoDir:   conf.APIInfoDir,
 		miners:       miners,
+		policies:     policies,
 		switchs:      switchs,
+		unavailable:  unavailable,
+		pinned:       pinned,
+		inventory:    inv,
+		hardware:     hardware,
 		repo:         r,
-		infoCache:    make(map[address.Address]workerInfoCache),
-		statsCache:   make(map[address.Address]workerStatsCache),
+		collector:    newCollector(),
+		conns:        newConnManager(),
 		parallel:     conf.Parallel,
+		exec:         ansibleExecutor{apiInfoDir: conf.APIInfoDir},
+		clock:        time.Now,
+		ap:           ap,
+		quota:        quota,
+		evac:         evac,
+		durations:    durations,
+	}
+
+	err = p.reconcile()
+	if err != nil {
+		return nil, err
 	}
+
+	for _, mi := range miners {
+		p.conns.add(mi.address, mi.url, p.clock())
+	}
+
+	p.runCollector(time.Duration(conf.CollectInterval))
+	p.runConnManager(time.Duration(conf.ProbeInterval))
 	p.run()
+	p.runAutopilot()
+	p.runEvacuation()
+	p.runRecorder(conf.Recorder)
 	return p, nil
 }
 
@@ -92,6 +224,7 @@ func (p *Pilot) run() {
 		for {
 			select {
 			case <-t.C:
+				p.trackDurations()
 				p.process()
 			case <-p.ctx.Done():
 				return
@@ -117,7 +250,7 @@ func (p *Pilot) createScript(id string) error {
 
 	if id == "all" {
 		for _, mi := range p.miners {
-			err := p.repo.CreateScript(mi.address, mi.token, mi.size)
+			err := p.repo.CreateScript(mi.address, mi.token, mi.size, p.apiInfoDir)
 			if err != nil {
 				return err
 			}
@@ -134,7 +267,7 @@ func (p *Pilot) createScript(id string) error {
 	if !ok {
 		return fmt.Errorf("miner: %s not found", id)
 	}
-	err = p.repo.CreateScript(mi.address, mi.token, mi.size)
+	err = p.repo.CreateScript(mi.address, mi.token, mi.size, p.apiInfoDir)
 	if err != nil {
 		return err
 	}
@@ -142,36 +275,111 @@ func (p *Pilot) createScript(id string) error {
 	return nil
 }
 
-func toMinerInfo(ctx context.Context, m string, info config.APIInfo) (MinerInfo, error) {
+func toMinerInfo(ctx context.Context, r *repo.Repo, m string, info config.APIInfo) (MinerInfo, error) {
 	maddr, err := address.NewFromString(m)
 	if err != nil {
 		return MinerInfo{}, err
 	}
 
-	if info.Addr == "" || info.Token == "" {
-		return MinerInfo{}, fmt.Errorf("miner: %s info is empty", m)
+	ep, err := resolveEndpoint(r, info)
+	if err != nil {
+		return MinerInfo{}, fmt.Errorf("miner: %s %w", m, err)
+	}
+	if info.MinWorkers < 0 || info.MaxWorkers < 0 || (info.MaxWorkers != 0 && info.MinWorkers > info.MaxWorkers) {
+		return MinerInfo{}, fmt.Errorf("miner: %s minWorkers: %d maxWorkers: %d illegal", m, info.MinWorkers, info.MaxWorkers)
 	}
 
-	addr := "ws://" + info.Addr + "/rpc/v0"
-	headers := http.Header{"Authorization": []string{"Bearer " + info.Token}}
-	api, closer, err := client.NewStorageMinerRPCV0(ctx, addr, headers)
+	api, closer, err := dialMiner(ctx, maddr, ep, info.CAFile, 0)
 	if err != nil {
 		return MinerInfo{}, err
 	}
 
-	apiAddr, err := api.ActorAddress(ctx)
+	size, err := api.ActorSectorSize(ctx, maddr)
 	if err != nil {
+		closer()
 		return MinerInfo{}, err
 	}
+	log.Infow("connected to miner", "miner", maddr, "addr", ep.URL())
+
+	return MinerInfo{
+		api:        api,
+		closer:     closer,
+		address:    maddr,
+		size:       size,
+		token:      ep.APIInfo(),
+		url:        ep.URL(),
+		info:       info,
+		minWorkers: info.MinWorkers,
+		maxWorkers: info.MaxWorkers,
+	}, nil
+}
+
+// resolveEndpoint 解析miner API信息并读取token引用
+func resolveEndpoint(r *repo.Repo, info config.APIInfo) (config.Endpoint, error) {
+	ep, err := info.Parse()
+	if err != nil {
+		return config.Endpoint{}, err
+	}
+	token, err := r.ResolveToken(ep.Token)
+	if err != nil {
+		return config.Endpoint{}, fmt.Errorf("resolve token %s: %w", config.RedactToken(ep.Token), err)
+	}
+	ep.Token = token
+	return ep, nil
+}
+
+// dialMiner 连接miner API并检查actor地址，ctx为连接的生命周期，timeout为检查的超时，0为不限制
+func dialMiner(ctx context.Context, maddr address.Address, ep config.Endpoint, caFile string, timeout time.Duration) (v0api.StorageMiner, jsonrpc.ClientCloser, error) {
+	var opts []jsonrpc.Option
+	if ep.TLS {
+		hc, err := tlsClient(caFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, jsonrpc.WithHTTPClient(hc))
+	}
+
+	headers := http.Header{"Authorization": []string{"Bearer " + ep.Token}}
+	api, closer, err := client.NewStorageMinerRPCV0(ctx, ep.URL(), headers, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cctx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		cctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	apiAddr, err := api.ActorAddress(cctx)
+	if err != nil {
+		closer()
+		return nil, nil, err
+	}
 	if apiAddr != maddr {
 		closer()
-		return MinerInfo{}, fmt.Errorf("maddr not match, config maddr: %s, api maddr: %s", maddr, apiAddr)
+		return nil, nil, fmt.Errorf("maddr not match, config maddr: %s, api maddr: %s", maddr, apiAddr)
 	}
-	size, err := api.ActorSectorSize(ctx, maddr)
-	if err != nil {
-		return MinerInfo{}, err
+	return api, closer, nil
+}
+
+// tlsClient 返回校验caFile签发证书的http client，caFile为空时使用系统CA
+func tlsClient(caFile string) (*http.Client, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in ca file: %s", caFile)
+		}
+		conf.RootCAs = pool
 	}
-	log.Infow("connected to miner", "miner", maddr, "addr", info.Addr)
 
-	return MinerInfo{api: api, closer: closer, address: maddr, size: size, token: info.ToAPIInfo()}, nil
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = conf
+	return &http.Client{Transport: transport}, nil
 }
@@ -0,0 +1,93 @@
+package pilot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PinnedWorker 固定的worker，pilot不会自动选择，指定切换时需要force
+type PinnedWorker struct {
+	//hostname、workerID或者标签选择器(例如 storage=special)
+	Target string    `json:"target"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+func (p *Pilot) pin(pw PinnedWorker) error {
+	pw.Target = strings.TrimSpace(pw.Target)
+	if pw.Target == "" {
+		return errors.New("pin target is empty")
+	}
+	if strings.Contains(pw.Target, "=") {
+		if _, err := parseSelector(pw.Target); err != nil {
+			return err
+		}
+	}
+	pw.Time = p.clock()
+
+	p.hostLk.Lock()
+	defer p.hostLk.Unlock()
+
+	p.pinned[pw.Target] = pw
+	log.Infow("pin worker", "target", pw.Target, "reason", pw.Reason)
+
+	return p.writePin()
+}
+
+func (p *Pilot) unpin(target string) error {
+	p.hostLk.Lock()
+	defer p.hostLk.Unlock()
+
+	if _, ok := p.pinned[target]; !ok {
+		return fmt.Errorf("target: %s not pinned", target)
+	}
+
+	delete(p.pinned, target)
+	log.Infow("unpin worker", "target", target)
+
+	return p.writePin()
+}
+
+// isPinned worker的hostname、workerID或者标签匹配任意一个固定项
+func (p *Pilot) isPinned(wid uuid.UUID, hostname string) bool {
+	p.hostLk.RLock()
+	defer p.hostLk.RUnlock()
+
+	for target := range p.pinned {
+		if target == hostname || target == wid.String() {
+			return true
+		}
+		if strings.Contains(target, "=") && p.hostMatch(target, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pilot) listPinned() []PinnedWorker {
+	p.hostLk.RLock()
+	defer p.hostLk.RUnlock()
+
+	var out []PinnedWorker
+	for _, pw := range p.pinned {
+		out = append(out, pw)
+	}
+
+	return out
+}
+
+// write pin state to repo/state
+// caller need keep hostLk lock
+func (p *Pilot) writePin() error {
+	data, err := json.Marshal(p.pinned)
+	if err != nil {
+		return err
+	}
+
+	return p.repo.WritePinState(data)
+}
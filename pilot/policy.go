@@ -0,0 +1,125 @@
+package pilot
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
+)
+
+// checkPolicy 检查策略中的任务类型，并统一为短名称
+func checkPolicy(p config.Policy) (config.Policy, error) {
+	out := config.Policy{
+		StopAllTasks: p.StopAllTasks,
+		StopNoSector: p.StopNoSector,
+	}
+
+	for _, t := range p.SwitchTasks {
+		tt, err := parseSealingTask(t)
+		if err != nil {
+			return config.Policy{}, fmt.Errorf("policy switchTasks: %w", err)
+		}
+		out.SwitchTasks = append(out.SwitchTasks, tt.Short())
+	}
+
+	stop := map[string]struct{}{}
+	for _, t := range p.StopTasks {
+		tt, err := parseSealingTask(t)
+		if err != nil {
+			return config.Policy{}, fmt.Errorf("policy stopTasks: %w", err)
+		}
+		out.StopTasks = append(out.StopTasks, tt.Short())
+		stop[tt.Short()] = struct{}{}
+	}
+
+	if !out.StopAllTasks {
+		//停止时还有任务在运行会导致任务失败
+		if len(out.StopTasks) == 0 {
+			return config.Policy{}, errors.New("policy stopTasks is empty and stopAllTasks is false")
+		}
+		for _, t := range out.SwitchTasks {
+			if _, ok := stop[t]; !ok {
+				return config.Policy{}, fmt.Errorf("policy stopTasks should contain switchTasks: %s", t)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// policyFor 返回worker使用的策略，优先使用请求中的策略，其次使用sealing以外角色的策略，
+// 然后使用fromMiner配置的策略，最后使用默认策略。fromMiner配置的策略只用于sealing角色
+func (p *Pilot) policyFor(req SwitchRequest, from address.Address) config.Policy {
+	if req.Policy != nil {
+		return *req.Policy
+	}
+	if spec, ok := roleSpecs[req.Role]; ok && req.Role != RoleSealing {
+		return spec.policy
+	}
+
+	p.lk.RLock()
+	defer p.lk.RUnlock()
+
+	if pol, ok := p.policies[from]; ok {
+		return pol
+	}
+	return roleSpecs[RoleSealing].policy
+}
+
+func (w *WorkerInfo) canSwitch(pol config.Policy) bool {
+	for _, t := range pol.SwitchTasks {
+		if w.sum(t) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *WorkerInfo) canStop(pol config.Policy) bool {
+	if pol.StopNoSector && len(w.Sectors) != 0 {
+		return false
+	}
+
+	if !pol.StopAllTasks {
+		for _, t := range pol.StopTasks {
+			if w.sum(t) != 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	var all int
+	for _, v := range w.Runing {
+		all += v
+	}
+	for _, v := range w.Prepared {
+		all += v
+	}
+	for _, v := range w.Assigned {
+		all += v
+	}
+	for _, v := range w.Sched {
+		all += v
+	}
+
+	return all == 0
+}
+
+// parsePolicies 解析并检查config中每个miner的策略
+func parsePolicies(conf map[string]config.Policy) (map[address.Address]config.Policy, error) {
+	policies := map[address.Address]config.Policy{}
+	for miner, pol := range conf {
+		maddr, err := address.NewFromString(miner)
+		if err != nil {
+			return nil, err
+		}
+		pol, err = checkPolicy(pol)
+		if err != nil {
+			return nil, fmt.Errorf("miner: %s %w", miner, err)
+		}
+		policies[maddr] = pol
+	}
+	return policies, nil
+}
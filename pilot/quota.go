@@ -0,0 +1,237 @@
+package pilot
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// quotaTracker 根据WorkerJobs中PC1任务的消失统计每个miner完成的PC1，
+// PC1消失后sector进入后续任务才认为完成
+type quotaTracker struct {
+	lk sync.Mutex
+	//上一次看到正在运行PC1的sector
+	Running map[string]map[abi.SectorNumber]struct{} `json:"running"`
+	//PC1已经消失，还没有看到后续任务的sector和PC1消失的时间
+	Pending map[string]map[abi.SectorNumber]time.Time `json:"pending"`
+	//完成PC1的时间，只保留最近24小时
+	Completions map[string][]time.Time `json:"completions"`
+}
+
+func loadQuotaTracker(data []byte) (*quotaTracker, error) {
+	qt := &quotaTracker{}
+	err := json.Unmarshal(data, qt)
+	if err != nil {
+		return nil, err
+	}
+	if qt.Running == nil {
+		qt.Running = map[string]map[abi.SectorNumber]struct{}{}
+	}
+	if qt.Pending == nil {
+		qt.Pending = map[string]map[abi.SectorNumber]time.Time{}
+	}
+	if qt.Completions == nil {
+		qt.Completions = map[string][]time.Time{}
+	}
+	return qt, nil
+}
+
+// afterPC1 PC1完成后sector会进入的任务
+var afterPC1 = map[sealtasks.TaskType]struct{}{
+	sealtasks.TTPreCommit2: {},
+	sealtasks.TTCommit1:    {},
+	sealtasks.TTCommit2:    {},
+	sealtasks.TTFinalize:   {},
+}
+
+// observe 记录一次WorkerJobs和调度队列，上次运行中的PC1这次不在运行时先记为pending，
+// pending的sector出现在后续任务(运行中或者调度队列)中才认为完成，重新运行PC1则不计算，返回新完成的数量
+func (qt *quotaTracker) observe(miner address.Address, jobs jobs, diag SchedDiagInfo, now time.Time) int {
+	qt.lk.Lock()
+	defer qt.lk.Unlock()
+
+	running := map[abi.SectorNumber]struct{}{}
+	later := map[abi.SectorNumber]struct{}{}
+	for _, js := range jobs {
+		for _, job := range js {
+			if job.Task == sealtasks.TTPreCommit1 && job.RunWait == storiface.RWRunning {
+				running[job.Sector.Number] = struct{}{}
+			}
+			if _, ok := afterPC1[job.Task]; ok {
+				later[job.Sector.Number] = struct{}{}
+			}
+		}
+	}
+	for _, req := range diag.Requests {
+		if _, ok := afterPC1[req.TaskType]; ok {
+			later[req.Sector.Number] = struct{}{}
+		}
+	}
+
+	pending, ok := qt.Pending[miner.String()]
+	if !ok {
+		pending = map[abi.SectorNumber]time.Time{}
+		qt.Pending[miner.String()] = pending
+	}
+	for sector := range qt.Running[miner.String()] {
+		if _, ok := running[sector]; !ok {
+			pending[sector] = now
+		}
+	}
+	qt.Running[miner.String()] = running
+
+	done := 0
+	for sector, t := range pending {
+		if _, ok := running[sector]; ok {
+			delete(pending, sector)
+			continue
+		}
+		if _, ok := later[sector]; ok {
+			qt.Completions[miner.String()] = append(qt.Completions[miner.String()], now)
+			done += 1
+			delete(pending, sector)
+			continue
+		}
+		if now.Sub(t) >= time.Hour*24 {
+			delete(pending, sector)
+		}
+	}
+
+	var keep []time.Time
+	for _, t := range qt.Completions[miner.String()] {
+		if now.Sub(t) < time.Hour*24 {
+			keep = append(keep, t)
+		}
+	}
+	qt.Completions[miner.String()] = keep
+
+	return done
+}
+
+// count 返回since之后完成的PC1数量
+func (qt *quotaTracker) count(miner address.Address, since time.Time) int {
+	qt.lk.Lock()
+	defer qt.lk.Unlock()
+
+	n := 0
+	for _, t := range qt.Completions[miner.String()] {
+		if !t.Before(since) {
+			n += 1
+		}
+	}
+	return n
+}
+
+func (qt *quotaTracker) marshal() ([]byte, error) {
+	qt.lk.Lock()
+	defer qt.lk.Unlock()
+
+	return json.Marshal(qt)
+}
+
+// trackQuota 获取每个有配额的miner的WorkerJobs并更新完成的PC1
+func (p *Pilot) trackQuota() {
+	now := p.clock()
+	for m := range p.ap.conf.Quota.Sectors {
+		miner, err := address.NewFromString(m)
+		if err != nil {
+			continue
+		}
+		if !p.hasMiner(miner) {
+			continue
+		}
+
+		snap, err := p.snapshot(miner)
+		if err != nil {
+			log.Warnw("trackQuota snapshot", "miner", miner, "err", err)
+			continue
+		}
+		//miner重启等情况下没有worker，不能认为运行中的PC1已经完成
+		if len(snap.stats) == 0 || len(snap.jobs) == 0 {
+			log.Debugw("trackQuota skip empty snapshot", "miner", miner)
+			continue
+		}
+		if n := p.quota.observe(miner, snap.jobs, snap.diag, now); n != 0 {
+			log.Debugw("trackQuota", "miner", miner, "pc1Done", n)
+		}
+	}
+
+	data, err := p.quota.marshal()
+	if err != nil {
+		log.Errorw("trackQuota marshal", "err", err)
+		return
+	}
+	err = p.repo.WriteQuotaState(data)
+	if err != nil {
+		log.Errorw("WriteQuotaState", "err", err)
+	}
+}
+
+// quotaTargets quota模式：根据今天已完成的数量和最近的产能预测今天能否完成配额，
+// 预计会超过配额的miner减少worker，预计完不成配额的miner增加worker
+func (p *Pilot) quotaTargets(counts map[address.Address]int, ev map[string]MinerEvidence) map[address.Address]int {
+	now := p.clock()
+	year, month, day := now.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	remain := midnight.Add(time.Hour * 24).Sub(now).Hours()
+
+	window := time.Duration(p.ap.conf.Quota.Window)
+	if window <= 0 {
+		window = time.Hour * 6
+	}
+
+	//每个worker每小时完成的PC1，没有历史数据的miner使用所有miner的平均值
+	perWorker := map[address.Address]float64{}
+	var allDone, allWorkers int
+	for miner, c := range counts {
+		done := p.quota.count(miner, now.Add(-window))
+		allDone += done
+		allWorkers += c
+		if c != 0 && done != 0 {
+			perWorker[miner] = float64(done) / window.Hours() / float64(c)
+		}
+	}
+	avg := 0.0
+	if allWorkers != 0 {
+		avg = float64(allDone) / window.Hours() / float64(allWorkers)
+	}
+
+	desired := map[address.Address]int{}
+	for miner, c := range counts {
+		e := MinerEvidence{
+			Workers: c,
+			Desired: c,
+		}
+		desired[miner] = c
+
+		quota, ok := p.ap.conf.Quota.Sectors[miner.String()]
+		if !ok {
+			ev[miner.String()] = e
+			continue
+		}
+
+		rate, ok := perWorker[miner]
+		if !ok {
+			rate = avg
+		}
+		e.Quota = quota
+		e.Done = p.quota.count(miner, midnight)
+		e.Rate = rate * float64(c)
+		e.Projected = float64(e.Done) + e.Rate*remain
+
+		if rate > 0 {
+			need := math.Max(float64(quota-e.Done), 0) / remain
+			e.Desired = int(math.Ceil(need / rate))
+			desired[miner] = e.Desired
+		}
+		ev[miner.String()] = e
+	}
+
+	return desired
+}
@@ -0,0 +1,170 @@
+package pilot
+
+import (
+	"github.com/filecoin-project/go-address"
+	"github.com/google/uuid"
+)
+
+// reconcile 启动时根据miner的实时worker状态修正switch.json中未完成的worker状态
+// pilot停止期间worker可能已经被手动启动或停止
+func (p *Pilot) reconcile() error {
+	p.swLk.Lock()
+	defer p.swLk.Unlock()
+
+	stats := map[address.Address]wst{}
+	getStats := func(ma address.Address) (wst, error) {
+		if st, ok := stats[ma]; ok {
+			return st, nil
+		}
+		st, err := p.workerStats(ma)
+		if err != nil {
+			return nil, err
+		}
+		stats[ma] = st
+		return st, nil
+	}
+
+	needWrite := false
+	for _, ss := range p.switchs {
+		if ss.State != StateSwitching {
+			continue
+		}
+
+		var to wst
+		if ss.Req.Type != SwitchTypeDrain {
+			var err error
+			to, err = getStats(ss.Req.To)
+			if err != nil {
+				log.Warnw("reconcile skip switch", "switchID", ss.ID, "to", ss.Req.To, "err", err)
+				continue
+			}
+		}
+
+		for wid, ws := range ss.Worker {
+			if ws.State == StateWorkerComplete || ws.State == StateWorkerError {
+				continue
+			}
+
+			toID, onTo := workerByHostname(to, ws.Hostname)
+			onFrom := false
+			if ss.Req.Type != SwitchTypeAttach {
+				from, err := getStats(ws.From)
+				if err != nil {
+					log.Warnw("reconcile skip worker", "switchID", ss.ID, "workerID", wid, "from", ws.From, "err", err)
+					continue
+				}
+				_, onFrom = from[wid]
+			}
+
+			var state StateWorker
+			switch ss.Req.Type {
+			case SwitchTypeDrain:
+				state = reconcileDrainState(ws.State, onFrom)
+			case SwitchTypeAttach:
+				state = reconcileAttachState(ws.State, onTo)
+			default:
+				state = reconcileState(ws.State, onFrom, onTo)
+			}
+			if state == ws.State {
+				continue
+			}
+
+			log.Infow("reconcile worker state", "switchID", ss.ID, "workerID", wid, "hostname", ws.Hostname,
+				"onFrom", onFrom, "onTo", onTo, "old", ws.State, "new", state)
+			if state == StateWorkerComplete && ss.Req.Type == SwitchTypeDrain {
+				err := p.markUnavailable(ws.Hostname, ss.ID)
+				if err != nil {
+					log.Errorw("markUnavailable", "switchID", ss.ID, "hostname", ws.Hostname, "err", err)
+				}
+			}
+			if state == StateWorkerComplete && ss.Req.Type == SwitchTypeAttach {
+				ws.WorkerID = toID
+				p.markAttached(ws.Hostname)
+			}
+			if state == StateWorkerError {
+				ws.Resume = ws.State
+				ws.ErrMsg = "reconcile: worker not found in from and to miner"
+			}
+			ws.State = state
+			ws.Try = 0
+			needWrite = true
+		}
+
+		ss.rekeyWorkers()
+		ss.updateState()
+	}
+
+	if needWrite {
+		return p.writeSwitch()
+	}
+	return nil
+}
+
+// reconcileState 根据worker是否在from/to上返回修正后的状态
+func reconcileState(state StateWorker, onFrom, onTo bool) StateWorker {
+	if !onFrom && !onTo {
+		//worker已经不在from也不在to上，需要人工处理
+		return StateWorkerError
+	}
+
+	switch state {
+	case StateWorkerPicked, StateWorkerDisableTasksConfirming, StateWorkerSwitchWaiting, StateWorkerSwitchConfirming:
+		if onTo && onFrom {
+			return StateWorkerStopWaiting
+		}
+		if onTo {
+			return StateWorkerComplete
+		}
+		if state == StateWorkerSwitchConfirming {
+			//to上没有找到，重新启动
+			return StateWorkerSwitchWaiting
+		}
+	case StateWorkerStopWaiting, StateWorkerStopConfirming:
+		if !onFrom {
+			return StateWorkerComplete
+		}
+		if !onTo {
+			//to上没有运行，不能停止from上唯一的worker，重新启动
+			return StateWorkerSwitchWaiting
+		}
+		if state == StateWorkerStopConfirming {
+			//from上仍然存在，重新停止
+			return StateWorkerStopWaiting
+		}
+	}
+
+	return state
+}
+
+// reconcileDrainState drain只需要关心worker是否还在from上
+func reconcileDrainState(state StateWorker, onFrom bool) StateWorker {
+	if !onFrom {
+		return StateWorkerComplete
+	}
+	if state == StateWorkerStopConfirming {
+		return StateWorkerStopWaiting
+	}
+
+	return state
+}
+
+// reconcileAttachState attach只需要关心worker是否已经在to上
+func reconcileAttachState(state StateWorker, onTo bool) StateWorker {
+	if onTo {
+		return StateWorkerComplete
+	}
+	if state == StateWorkerSwitchConfirming {
+		return StateWorkerSwitchWaiting
+	}
+
+	return state
+}
+
+func workerByHostname(st wst, hostname string) (uuid.UUID, bool) {
+	for wid, w := range st {
+		if w.Info.Hostname == hostname {
+			return wid, true
+		}
+	}
+	return uuid.Nil, false
+}
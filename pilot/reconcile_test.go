@@ -0,0 +1,94 @@
+package pilot
+
+import "testing"
+
+func TestReconcileState(t *testing.T) {
+	tests := []struct {
+		state  StateWorker
+		onFrom bool
+		onTo   bool
+		expect StateWorker
+	}{
+		{StateWorkerPicked, true, false, StateWorkerPicked},
+		{StateWorkerPicked, true, true, StateWorkerStopWaiting},
+		{StateWorkerPicked, false, true, StateWorkerComplete},
+		{StateWorkerPicked, false, false, StateWorkerError},
+		{StateWorkerDisableTasksConfirming, true, false, StateWorkerDisableTasksConfirming},
+		{StateWorkerDisableTasksConfirming, true, true, StateWorkerStopWaiting},
+		{StateWorkerDisableTasksConfirming, false, true, StateWorkerComplete},
+		{StateWorkerDisableTasksConfirming, false, false, StateWorkerError},
+		{StateWorkerSwitchWaiting, true, false, StateWorkerSwitchWaiting},
+		{StateWorkerSwitchWaiting, true, true, StateWorkerStopWaiting},
+		{StateWorkerSwitchWaiting, false, true, StateWorkerComplete},
+		{StateWorkerSwitchWaiting, false, false, StateWorkerError},
+		//to上没有找到，重新启动
+		{StateWorkerSwitchConfirming, true, false, StateWorkerSwitchWaiting},
+		{StateWorkerSwitchConfirming, true, true, StateWorkerStopWaiting},
+		{StateWorkerSwitchConfirming, false, true, StateWorkerComplete},
+		{StateWorkerSwitchConfirming, false, false, StateWorkerError},
+		//to上没有运行，不能停止from上唯一的worker
+		{StateWorkerStopWaiting, true, false, StateWorkerSwitchWaiting},
+		{StateWorkerStopWaiting, true, true, StateWorkerStopWaiting},
+		{StateWorkerStopWaiting, false, true, StateWorkerComplete},
+		{StateWorkerStopWaiting, false, false, StateWorkerError},
+		//to上没有运行，重新启动
+		{StateWorkerStopConfirming, true, false, StateWorkerSwitchWaiting},
+		//from上仍然存在，重新停止
+		{StateWorkerStopConfirming, true, true, StateWorkerStopWaiting},
+		{StateWorkerStopConfirming, false, true, StateWorkerComplete},
+		{StateWorkerStopConfirming, false, false, StateWorkerError},
+	}
+
+	for _, tt := range tests {
+		got := reconcileState(tt.state, tt.onFrom, tt.onTo)
+		if got != tt.expect {
+			t.Errorf("state: %d onFrom: %v onTo: %v got: %d expect: %d", tt.state, tt.onFrom, tt.onTo, got, tt.expect)
+		}
+	}
+}
+
+func TestReconcileDrainState(t *testing.T) {
+	tests := []struct {
+		state  StateWorker
+		onFrom bool
+		expect StateWorker
+	}{
+		{StateWorkerPicked, true, StateWorkerPicked},
+		{StateWorkerPicked, false, StateWorkerComplete},
+		{StateWorkerDisableTasksConfirming, true, StateWorkerDisableTasksConfirming},
+		{StateWorkerDisableTasksConfirming, false, StateWorkerComplete},
+		{StateWorkerStopWaiting, true, StateWorkerStopWaiting},
+		{StateWorkerStopWaiting, false, StateWorkerComplete},
+		{StateWorkerStopConfirming, true, StateWorkerStopWaiting},
+		{StateWorkerStopConfirming, false, StateWorkerComplete},
+	}
+
+	for _, tt := range tests {
+		got := reconcileDrainState(tt.state, tt.onFrom)
+		if got != tt.expect {
+			t.Errorf("state: %d onFrom: %v got: %d expect: %d", tt.state, tt.onFrom, got, tt.expect)
+		}
+	}
+}
+
+func TestReconcileAttachState(t *testing.T) {
+	tests := []struct {
+		state  StateWorker
+		onTo   bool
+		expect StateWorker
+	}{
+		{StateWorkerPicked, false, StateWorkerPicked},
+		{StateWorkerPicked, true, StateWorkerComplete},
+		{StateWorkerSwitchWaiting, false, StateWorkerSwitchWaiting},
+		{StateWorkerSwitchWaiting, true, StateWorkerComplete},
+		{StateWorkerSwitchConfirming, false, StateWorkerSwitchWaiting},
+		{StateWorkerSwitchConfirming, true, StateWorkerComplete},
+	}
+
+	for _, tt := range tests {
+		got := reconcileAttachState(tt.state, tt.onTo)
+		if got != tt.expect {
+			t.Errorf("state: %d onTo: %v got: %d expect: %d", tt.state, tt.onTo, got, tt.expect)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package pilot
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
+)
+
+// Snapshot 某一时刻miner的原始worker数据，用于离线模拟
+type Snapshot struct {
+	Time        time.Time       `json:"time"`
+	Miner       address.Address `json:"miner"`
+	SectorSize  abi.SectorSize  `json:"sectorSize"`
+	WorkerStats wst             `json:"workerStats"`
+	WorkerJobs  jobs            `json:"workerJobs"`
+	StorageList sts             `json:"storageList"`
+	SchedDiag   SchedDiagInfo   `json:"schedDiag"`
+}
+
+// runRecorder 定期把每个miner的WorkerStats、WorkerJobs、StorageList和SchedDiagInfo保存到repo
+func (p *Pilot) runRecorder(conf config.Recorder) {
+	if !conf.Enable {
+		return
+	}
+	interval := time.Duration(conf.Interval)
+	if interval <= 0 {
+		log.Warnw("recorder interval illegal, recorder disabled", "interval", interval)
+		return
+	}
+	log.Infow("recorder enabled", "interval", interval, "retention", time.Duration(conf.Retention))
+
+	go func() {
+		t := time.NewTicker(interval)
+		for {
+			select {
+			case <-t.C:
+				p.record()
+				if conf.Retention > 0 {
+					err := p.repo.PruneRecords(p.clock().Add(-time.Duration(conf.Retention)))
+					if err != nil {
+						log.Errorw("PruneRecords", "err", err)
+					}
+				}
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (p *Pilot) record() {
+	for _, miner := range p.minerList() {
+		ms, err := p.snapshot(miner)
+		if err != nil {
+			log.Warnw("record snapshot", "miner", miner, "err", err)
+			continue
+		}
+
+		snap := Snapshot{
+			Time:        ms.time,
+			Miner:       miner,
+			SectorSize:  p.minerSectorSize(miner),
+			WorkerStats: ms.stats,
+			WorkerJobs:  ms.jobs,
+			StorageList: ms.sts,
+			SchedDiag:   ms.diag,
+		}
+		data, err := json.Marshal(&snap)
+		if err != nil {
+			log.Errorw("record marshal", "miner", miner, "err", err)
+			continue
+		}
+		err = p.repo.WriteRecord(miner.String(), ms.time, data)
+		if err != nil {
+			log.Errorw("WriteRecord", "miner", miner, "err", err)
+		}
+	}
+}
+
+func (p *Pilot) minerSectorSize(ma address.Address) abi.SectorSize {
+	p.lk.RLock()
+	defer p.lk.RUnlock()
+
+	return p.miners[ma].size
+}
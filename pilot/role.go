@@ -0,0 +1,123 @@
+package pilot
+
+import (
+	"fmt"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
+)
+
+// WorkerRole worker在封存流程中的角色，一个worker可以有多个角色
+type WorkerRole string
+
+const (
+	//CC封存，PC1 PC2
+	RoleSealing WorkerRole = "sealing"
+	//SnapDeal，RU PR1 PR2
+	RoleSnap WorkerRole = "snap"
+	//C2
+	RoleCommit WorkerRole = "commit"
+)
+
+type roleSpec struct {
+	//worker启用其中任一任务即属于该角色
+	tasks []sealtasks.TaskType
+	//选择worker时优先比较的任务负载
+	load []string
+	//其次比较的任务负载
+	next []string
+	//按该任务最近开始时间排序，越早越先选择
+	start string
+	//默认的切换和停止条件
+	policy config.Policy
+	//backlog模式：调度队列中等待这些任务的sector计入积压
+	backlogTasks []string
+	//backlog模式：处于这些状态的sector计入积压
+	backlogStates []string
+}
+
+var roleSpecs = map[WorkerRole]roleSpec{
+	RoleSealing: {
+		tasks:  []sealtasks.TaskType{sealtasks.TTPreCommit1, sealtasks.TTPreCommit2},
+		load:   []string{"AP", "PC1"},
+		next:   []string{"PC2"},
+		start:  "PC1",
+		policy: config.DefaultPolicy(),
+
+		backlogTasks:  []string{"AP", "PC1"},
+		backlogStates: []string{"WaitDeals", "AddPiece"},
+	},
+	RoleSnap: {
+		tasks: []sealtasks.TaskType{sealtasks.TTReplicaUpdate, sealtasks.TTProveReplicaUpdate1, sealtasks.TTProveReplicaUpdate2},
+		load:  []string{"AP", "RU"},
+		next:  []string{"PR1", "PR2"},
+		start: "RU",
+		policy: config.Policy{
+			SwitchTasks:  []string{"AP", "RU", "PR1", "PR2"},
+			StopAllTasks: true,
+			StopNoSector: true,
+		},
+
+		backlogTasks:  []string{"AP", "RU"},
+		backlogStates: []string{"SnapDealsWaitDeals", "SnapDealsAddPiece"},
+	},
+	RoleCommit: {
+		tasks: []sealtasks.TaskType{sealtasks.TTCommit2},
+		load:  []string{"C2"},
+		start: "C2",
+		policy: config.Policy{
+			SwitchTasks:  []string{"C2"},
+			StopAllTasks: true,
+			StopNoSector: true,
+		},
+
+		backlogTasks: []string{"C2"},
+	},
+}
+
+func checkRole(role WorkerRole) error {
+	if _, ok := roleSpecs[role]; !ok {
+		return fmt.Errorf("unknown worker role: %s", role)
+	}
+	return nil
+}
+
+// workerRoles 根据worker启用的任务返回它的角色
+func workerRoles(st storiface.WorkerStats) []WorkerRole {
+	var out []WorkerRole
+	for _, role := range []WorkerRole{RoleSealing, RoleSnap, RoleCommit} {
+		if hasRole(st, role) {
+			out = append(out, role)
+		}
+	}
+	return out
+}
+
+func hasRole(st storiface.WorkerStats, role WorkerRole) bool {
+	for _, t := range st.Tasks {
+		for _, rt := range roleSpecs[role].tasks {
+			if t == rt {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (w *WorkerInfo) hasRole(role WorkerRole) bool {
+	for _, r := range w.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *WorkerInfo) sumOf(tts []string) int {
+	var out int
+	for _, tt := range tts {
+		out += w.sum(tt)
+	}
+	return out
+}
@@ -0,0 +1,566 @@
+package pilot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/storage/sealer/fsutil"
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+	"github.com/gh-efforts/lotus-pilot/repo"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
+	"github.com/google/uuid"
+)
+
+// SimulateOptions 模拟的时间范围和切换来源
+type SimulateOptions struct {
+	//零值表示不限制
+	Since time.Time
+	Until time.Time
+	//按config中的autopilot配置模拟自动切换，dryRun会被忽略
+	Autopilot bool
+	//模拟开始时提交的切换请求
+	Requests []SwitchRequest
+}
+
+type SimulateReport struct {
+	Start     time.Time       `json:"start"`
+	End       time.Time       `json:"end"`
+	Snapshots int             `json:"snapshots"`
+	Moves     []SimulatedMove `json:"moves"`
+	Errors    []string        `json:"errors"`
+}
+
+// SimulatedMove 模拟中一个worker的切换过程
+type SimulatedMove struct {
+	SwitchID uuid.UUID       `json:"switchID"`
+	Type     SwitchType      `json:"type"`
+	Source   string          `json:"source"`
+	Hostname string          `json:"hostname"`
+	From     address.Address `json:"from"`
+	To       address.Address `json:"to"`
+	State    string          `json:"state"`
+	Picked   time.Time       `json:"picked"`
+	//在to上启动的时间
+	Started time.Time `json:"started"`
+	//从from上停止的时间
+	Stopped time.Time `json:"stopped"`
+	//被选中后到启动(drain为停止)前，worker上没有运行任务的时间
+	Idle config.Duration `json:"idle"`
+}
+
+type moveKey struct {
+	switchID uuid.UUID
+	hostname string
+}
+
+// simulator 按时间回放记录的snapshot，executor不执行命令，只修改回放的worker数据
+type simulator struct {
+	lk    sync.Mutex
+	now   time.Time
+	snaps map[address.Address][]Snapshot
+	cur   map[address.Address]int
+
+	//被停止的worker，key为miner和hostname
+	removed map[address.Address]map[string]struct{}
+	//在miner上启动的worker
+	added map[address.Address]map[string]storiface.WorkerStats
+	//禁止的任务
+	disabled map[address.Address]map[string]disabledTasks
+
+	moves map[moveKey]*SimulatedMove
+}
+
+type disabledTasks struct {
+	at    time.Time
+	tasks []sealtasks.TaskType
+}
+
+// Simulate 用repo中记录的snapshot回放workerPick和切换策略，报告会发生的切换和worker空闲的时间
+func Simulate(ctx context.Context, r *repo.Repo, opts SimulateOptions) (*SimulateReport, error) {
+	conf, err := r.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := r.ReadRecords(opts.Since, opts.Until)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no records found, enable recorder in config first")
+	}
+
+	sim := &simulator{
+		snaps:    map[address.Address][]Snapshot{},
+		cur:      map[address.Address]int{},
+		removed:  map[address.Address]map[string]struct{}{},
+		added:    map[address.Address]map[string]storiface.WorkerStats{},
+		disabled: map[address.Address]map[string]disabledTasks{},
+		moves:    map[moveKey]*SimulatedMove{},
+	}
+	var times []time.Time
+	seen := map[int64]struct{}{}
+	for _, data := range records {
+		var snap Snapshot
+		err := json.Unmarshal(data, &snap)
+		if err != nil {
+			return nil, err
+		}
+		sim.snaps[snap.Miner] = append(sim.snaps[snap.Miner], snap)
+		if _, ok := seen[snap.Time.Unix()]; !ok {
+			seen[snap.Time.Unix()] = struct{}{}
+			times = append(times, snap.Time)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+
+	//模拟的状态写到临时repo，不影响正在运行的pilot
+	dir, err := os.MkdirTemp("", "lotus-pilot-simulate")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tr, err := repo.New(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = tr.Init()
+	if err != nil {
+		return nil, err
+	}
+
+	miners := map[address.Address]MinerInfo{}
+	for miner, snaps := range sim.snaps {
+		info := conf.Miners[miner.String()]
+		miners[miner] = MinerInfo{
+			api:        &replayMiner{sim: sim, miner: miner, size: snaps[0].SectorSize},
+			address:    miner,
+			size:       snaps[0].SectorSize,
+			minWorkers: info.MinWorkers,
+			maxWorkers: info.MaxWorkers,
+		}
+	}
+
+	policies, err := parsePolicies(conf.Policies)
+	if err != nil {
+		return nil, err
+	}
+
+	var ap *autopilot
+	if opts.Autopilot {
+		apConf := conf.Autopilot
+		apConf.Enable = true
+		apConf.DryRun = false
+		ap, err = newAutopilot(apConf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	inv, err := newInventory(conf.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	hardware, err := parseHardware(conf.Hardware)
+	if err != nil {
+		return nil, err
+	}
+	for size, hw := range hardware {
+		//snapshot没有记录封存路径的容量
+		hw.SealGiB = 0
+		hardware[size] = hw
+	}
+
+	quota, err := loadQuotaTracker([]byte("{}"))
+	if err != nil {
+		return nil, err
+	}
+	evac, err := newEvacuation(config.Evacuation{}, []byte("{}"))
+	if err != nil {
+		return nil, err
+	}
+	durations, err := loadDurationTracker([]byte("{}"))
+	if err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(conf.Interval)
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	p := &Pilot{
+		ctx:         ctx,
+		interval:    interval,
+		miners:      miners,
+		policies:    policies,
+		switchs:     map[uuid.UUID]*SwitchState{},
+		unavailable: map[string]UnavailableHost{},
+		pinned:      map[string]PinnedWorker{},
+		inventory:   inv,
+		hardware:    hardware,
+		repo:        tr,
+		collector:   newCollector(),
+		conns:       newConnManager(),
+		parallel:    1,
+		ap:          ap,
+		quota:       quota,
+		evac:        evac,
+		durations:   durations,
+		exec:        sim,
+		clock:       sim.clock,
+	}
+
+	report := &SimulateReport{
+		Start:     times[0],
+		End:       times[len(times)-1],
+		Snapshots: len(records),
+	}
+
+	var nextAutopilot time.Time
+	for i, t := range times {
+		step := interval
+		if i+1 < len(times) {
+			step = times[i+1].Sub(t)
+		}
+		rounds := max(1, int(step/interval))
+		roundStep := step / time.Duration(rounds)
+
+		for k := 0; k < rounds; k++ {
+			sim.advance(t.Add(roundStep * time.Duration(k)))
+
+			if i == 0 && k == 0 {
+				for _, req := range opts.Requests {
+					_, err := p.newSwitch(req)
+					if err != nil {
+						report.Errors = append(report.Errors, err.Error())
+					}
+				}
+			}
+			if p.ap != nil && !sim.clock().Before(nextAutopilot) {
+				p.autopilotRound()
+				for _, prop := range p.ap.getReport().Proposals {
+					if prop.Err != "" {
+						report.Errors = append(report.Errors, prop.Err)
+					}
+				}
+				nextAutopilot = sim.clock().Add(p.ap.interval)
+			}
+
+			sim.observe(p, 0)
+			p.trackDurations()
+			p.process()
+			sim.observe(p, roundStep)
+		}
+	}
+
+	for _, mv := range sim.moves {
+		report.Moves = append(report.Moves, *mv)
+	}
+	sort.Slice(report.Moves, func(i, j int) bool {
+		if !report.Moves[i].Picked.Equal(report.Moves[j].Picked) {
+			return report.Moves[i].Picked.Before(report.Moves[j].Picked)
+		}
+		return report.Moves[i].Hostname < report.Moves[j].Hostname
+	})
+
+	return report, nil
+}
+
+func (s *simulator) clock() time.Time {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return s.now
+}
+
+// advance 虚拟时钟前进到t，每个miner使用t之前最近的snapshot
+func (s *simulator) advance(t time.Time) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.now = t
+	for miner, snaps := range s.snaps {
+		i := s.cur[miner]
+		for i+1 < len(snaps) && !snaps[i+1].Time.After(t) {
+			i += 1
+		}
+		s.cur[miner] = i
+	}
+}
+
+// observe 记录worker状态的变化，step不为0时累计还没有启动的worker的空闲时间
+func (s *simulator) observe(p *Pilot, step time.Duration) {
+	p.swLk.RLock()
+	defer p.swLk.RUnlock()
+
+	now := s.clock()
+	for _, ss := range p.switchs {
+		for _, ws := range ss.Worker {
+			k := moveKey{switchID: ss.ID, hostname: ws.Hostname}
+			mv, ok := s.moves[k]
+			if !ok {
+				mv = &SimulatedMove{
+					SwitchID: ss.ID,
+					Type:     ss.Req.Type,
+					Source:   ss.Req.Source,
+					Hostname: ws.Hostname,
+					From:     ws.From,
+					To:       ss.Req.To,
+					Picked:   now,
+				}
+				s.moves[k] = mv
+			}
+			mv.State = ws.State.String()
+
+			switch {
+			case ws.State == StateWorkerError:
+			case ss.Req.Type == SwitchTypeDrain:
+				if mv.Stopped.IsZero() && ws.State >= StateWorkerStopConfirming {
+					mv.Stopped = now
+				}
+			default:
+				if mv.Started.IsZero() && ws.State >= StateWorkerSwitchConfirming {
+					mv.Started = now
+				}
+				if mv.Stopped.IsZero() && ws.State >= StateWorkerStopConfirming && ss.Req.Type != SwitchTypeAttach {
+					mv.Stopped = now
+				}
+			}
+
+			if step == 0 || ss.State != StateSwitching || ws.From.Empty() {
+				continue
+			}
+			waiting := mv.Started.IsZero()
+			if ss.Req.Type == SwitchTypeDrain {
+				waiting = mv.Stopped.IsZero()
+			}
+			if waiting && !s.running(ws.From, ws.Hostname) {
+				mv.Idle += config.Duration(step)
+			}
+		}
+	}
+}
+
+// running worker在回放的WorkerJobs中是否有运行中的任务
+func (s *simulator) running(miner address.Address, hostname string) bool {
+	st := s.workerStats(miner)
+	jobs := s.workerJobs(miner)
+	for wid, w := range st {
+		if w.Info.Hostname != hostname {
+			continue
+		}
+		for _, job := range jobs[wid] {
+			if job.RunWait == storiface.RWRunning {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *simulator) snapshot(miner address.Address) Snapshot {
+	snaps := s.snaps[miner]
+	if len(snaps) == 0 {
+		return Snapshot{}
+	}
+	return snaps[s.cur[miner]]
+}
+
+func (s *simulator) workerStats(miner address.Address) wst {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	out := wst{}
+	for wid, st := range s.snapshot(miner).WorkerStats {
+		if _, ok := s.removed[miner][st.Info.Hostname]; ok {
+			continue
+		}
+		if dt, ok := s.disabled[miner][st.Info.Hostname]; ok {
+			var tasks []sealtasks.TaskType
+			for _, t := range st.Tasks {
+				if !hasTask(dt.tasks, t) {
+					tasks = append(tasks, t)
+				}
+			}
+			st.Tasks = tasks
+		}
+		out[wid] = st
+	}
+	for hostname, st := range s.added[miner] {
+		out[simWorkerID(miner, hostname)] = st
+	}
+	return out
+}
+
+func (s *simulator) workerJobs(miner address.Address) jobs {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	snap := s.snapshot(miner)
+	out := jobs{}
+	for wid, js := range snap.WorkerJobs {
+		hostname := snap.WorkerStats[wid].Info.Hostname
+		if _, ok := s.removed[miner][hostname]; ok {
+			continue
+		}
+		dt, disabled := s.disabled[miner][hostname]
+		for _, job := range js {
+			//禁止任务后，记录中之后开始的任务和等待中的禁止任务不会再发生
+			if disabled && (job.Start.After(dt.at) || (job.RunWait != storiface.RWRunning && hasTask(dt.tasks, job.Task))) {
+				continue
+			}
+			out[wid] = append(out[wid], job)
+		}
+	}
+	return out
+}
+
+func hasTask(tasks []sealtasks.TaskType, t sealtasks.TaskType) bool {
+	for _, tt := range tasks {
+		if tt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// simWorkerID 模拟中在miner上启动的worker使用固定的workerID
+func simWorkerID(miner address.Address, hostname string) uuid.UUID {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(miner.String()+"/"+hostname))
+}
+
+func (s *simulator) disableTasks(ctx context.Context, hostname, miner string, tasks []sealtasks.TaskType) error {
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return err
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.disabled[maddr] == nil {
+		s.disabled[maddr] = map[string]disabledTasks{}
+	}
+	s.disabled[maddr][hostname] = disabledTasks{at: s.now, tasks: tasks}
+	return nil
+}
+
+func (s *simulator) enableTasks(ctx context.Context, hostname, miner string, tasks []sealtasks.TaskType) error {
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return err
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	delete(s.disabled[maddr], hostname)
+	return nil
+}
+
+func (s *simulator) workerRun(ctx context.Context, hostname, to, scriptsPath string) error {
+	maddr, err := address.NewFromString(to)
+	if err != nil {
+		return err
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	//使用机器在其他miner上的信息，新启动的worker没有任务
+	st := storiface.WorkerStats{Info: storiface.WorkerInfo{Hostname: hostname}, Enabled: true}
+	for miner := range s.snaps {
+		for _, w := range s.snapshot(miner).WorkerStats {
+			if w.Info.Hostname == hostname {
+				st = w
+			}
+		}
+	}
+	st.Enabled = true
+
+	if s.added[maddr] == nil {
+		s.added[maddr] = map[string]storiface.WorkerStats{}
+	}
+	s.added[maddr][hostname] = st
+	delete(s.removed[maddr], hostname)
+	return nil
+}
+
+func (s *simulator) workerStop(ctx context.Context, hostname, from string) error {
+	maddr, err := address.NewFromString(from)
+	if err != nil {
+		return err
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.removed[maddr] == nil {
+		s.removed[maddr] = map[string]struct{}{}
+	}
+	s.removed[maddr][hostname] = struct{}{}
+	delete(s.added[maddr], hostname)
+	delete(s.disabled[maddr], hostname)
+	return nil
+}
+
+// replayMiner 用回放的snapshot实现pilot用到的miner API，其他方法不会被调用
+type replayMiner struct {
+	v0api.StorageMiner
+
+	sim   *simulator
+	miner address.Address
+	size  abi.SectorSize
+}
+
+func (m *replayMiner) ActorAddress(context.Context) (address.Address, error) {
+	return m.miner, nil
+}
+
+func (m *replayMiner) ActorSectorSize(context.Context, address.Address) (abi.SectorSize, error) {
+	return m.size, nil
+}
+
+func (m *replayMiner) WorkerStats(context.Context) (map[uuid.UUID]storiface.WorkerStats, error) {
+	return m.sim.workerStats(m.miner), nil
+}
+
+func (m *replayMiner) WorkerJobs(context.Context) (map[uuid.UUID][]storiface.WorkerJob, error) {
+	return m.sim.workerJobs(m.miner), nil
+}
+
+func (m *replayMiner) StorageList(context.Context) (map[storiface.ID][]storiface.Decl, error) {
+	m.sim.lk.Lock()
+	defer m.sim.lk.Unlock()
+
+	return m.sim.snapshot(m.miner).StorageList, nil
+}
+
+func (m *replayMiner) SealingSchedDiag(context.Context, bool) (interface{}, error) {
+	m.sim.lk.Lock()
+	defer m.sim.lk.Unlock()
+
+	return SchedInfo{SchedInfo: m.sim.snapshot(m.miner).SchedDiag}, nil
+}
+
+// StorageStat 没有记录，模拟时不检查封存路径的容量
+func (m *replayMiner) StorageStat(context.Context, storiface.ID) (fsutil.FsStat, error) {
+	return fsutil.FsStat{}, errors.New("storage stat not recorded")
+}
+
+// SectorsSummary 没有记录，backlog模式只使用调度队列
+func (m *replayMiner) SectorsSummary(context.Context) (map[api.SectorState]int, error) {
+	return map[api.SectorState]int{}, nil
+}
@@ -0,0 +1,221 @@
+package pilot
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/google/uuid"
+)
+
+const (
+	//主要任务少，后续任务少，最近一次开始时间早，sector少的worker优先
+	StrategyDefault = "default"
+	//封存中的sector少，任务少的worker优先
+	StrategyLeastSectors = "least-sectors"
+	//hostname或者标签匹配StrategyArg的worker优先，其余按default排序
+	StrategyMatch = "match"
+	//CPU、内存、GPU多的worker优先
+	StrategyNewestHardware = "newest-hardware"
+	//随机选择
+	StrategyRandom = "random"
+	//根据学习的任务耗时，预计最快完成切换(drain为停止)的worker优先
+	StrategyETA = "eta"
+)
+
+// PickStrategy 按数量选择worker时的排序策略
+type PickStrategy interface {
+	// Keys 返回分数中每一项的含义
+	Keys(req SwitchRequest) []string
+	// Score 返回worker每一项的分数，依次比较，越小越先选择，全部相同时按hostname排序
+	Score(req SwitchRequest, w WorkerInfo) []float64
+}
+
+var pickStrategies = map[string]PickStrategy{
+	StrategyDefault:        defaultStrategy{},
+	StrategyLeastSectors:   leastSectorsStrategy{},
+	StrategyMatch:          matchStrategy{},
+	StrategyNewestHardware: newestHardwareStrategy{},
+	StrategyRandom:         randomStrategy{},
+	StrategyETA:            etaStrategy{},
+}
+
+// PickScore worker在选择策略下的分数，用于解释选择的顺序
+type PickScore struct {
+	WorkerID uuid.UUID       `json:"workerID"`
+	Hostname string          `json:"hostname"`
+	From     address.Address `json:"from"`
+	Keys     []string        `json:"keys"`
+	Values   []float64       `json:"values"`
+	Picked   bool            `json:"picked"`
+	//没有被选择的原因
+	Skip string `json:"skip"`
+}
+
+func checkStrategy(req *SwitchRequest) error {
+	if req.Strategy == "" {
+		return nil
+	}
+	if _, ok := pickStrategies[req.Strategy]; !ok {
+		return fmt.Errorf("unknown pick strategy: %s", req.Strategy)
+	}
+	if req.Strategy == StrategyMatch {
+		if req.StrategyArg == "" {
+			return errors.New("match strategy need strategyArg")
+		}
+		if strings.Contains(req.StrategyArg, "=") {
+			if _, err := parseSelector(req.StrategyArg); err != nil {
+				return err
+			}
+		} else if _, err := path.Match(req.StrategyArg, ""); err != nil {
+			return fmt.Errorf("match strategy pattern: %s %w", req.StrategyArg, err)
+		}
+	}
+	return nil
+}
+
+// pickStrategy 返回req的策略，eta策略需要预先估算每个候选worker的时间
+func (p *Pilot) pickStrategy(req SwitchRequest, candidates []pickCandidate) PickStrategy {
+	if req.Strategy == StrategyETA {
+		eta := map[uuid.UUID]WorkerETA{}
+		for _, c := range candidates {
+			eta[c.WorkerID] = p.estimateWorker(req, c.from, c.WorkerInfo)
+		}
+		return etaStrategy{eta: eta}
+	}
+
+	strategy, ok := pickStrategies[req.Strategy]
+	if !ok {
+		return defaultStrategy{}
+	}
+	return strategy
+}
+
+// scoreCandidates 按策略给worker打分并排序
+func scoreCandidates(req SwitchRequest, strategy PickStrategy, candidates []pickCandidate) []PickScore {
+	keys := strategy.Keys(req)
+
+	scores := make([]PickScore, 0, len(candidates))
+	for _, c := range candidates {
+		scores = append(scores, PickScore{
+			WorkerID: c.WorkerID,
+			Hostname: c.Hostname,
+			From:     c.from,
+			Keys:     keys,
+			Values:   strategy.Score(req, c.WorkerInfo),
+			Skip:     c.skip,
+		})
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		vi, vj := scores[i].Values, scores[j].Values
+		for k := 0; k < len(vi) && k < len(vj); k++ {
+			if vi[k] != vj[k] {
+				return vi[k] < vj[k]
+			}
+		}
+		return scores[i].Hostname < scores[j].Hostname
+	})
+
+	return scores
+}
+
+type defaultStrategy struct{}
+
+func (defaultStrategy) Keys(req SwitchRequest) []string {
+	r := roleSpecs[req.Role]
+	return []string{fmt.Sprintf("load%v", r.load), fmt.Sprintf("next%v", r.next), fmt.Sprintf("lastStart(%s)", r.start), "sectors"}
+}
+
+func (defaultStrategy) Score(req SwitchRequest, w WorkerInfo) []float64 {
+	r := roleSpecs[req.Role]
+	var lastStart float64
+	if t, ok := w.LastStart[r.start]; ok {
+		lastStart = float64(t.Unix())
+	}
+	return []float64{float64(w.sumOf(r.load)), float64(w.sumOf(r.next)), lastStart, float64(len(w.Sectors))}
+}
+
+type leastSectorsStrategy struct{}
+
+func (leastSectorsStrategy) Keys(req SwitchRequest) []string {
+	return []string{"sectors", "tasks"}
+}
+
+func (leastSectorsStrategy) Score(req SwitchRequest, w WorkerInfo) []float64 {
+	tasks := 0
+	for t := range w.Tasks {
+		tasks += w.sum(t)
+	}
+	return []float64{float64(len(w.Sectors)), float64(tasks)}
+}
+
+type matchStrategy struct{}
+
+func (matchStrategy) Keys(req SwitchRequest) []string {
+	return append([]string{fmt.Sprintf("unmatched(%s)", req.StrategyArg)}, defaultStrategy{}.Keys(req)...)
+}
+
+func (matchStrategy) Score(req SwitchRequest, w WorkerInfo) []float64 {
+	unmatched := 1.0
+	if strings.Contains(req.StrategyArg, "=") {
+		if sel, err := parseSelector(req.StrategyArg); err == nil && matchSelector(sel, w.Labels) {
+			unmatched = 0
+		}
+	} else if ok, _ := path.Match(req.StrategyArg, w.Hostname); ok {
+		unmatched = 0
+	}
+	return append([]float64{unmatched}, defaultStrategy{}.Score(req, w)...)
+}
+
+type newestHardwareStrategy struct{}
+
+func (newestHardwareStrategy) Keys(req SwitchRequest) []string {
+	return []string{"-cpus", "-memGiB", "-gpus"}
+}
+
+func (newestHardwareStrategy) Score(req SwitchRequest, w WorkerInfo) []float64 {
+	res := w.Resources
+	return []float64{-float64(res.CPUs), -float64(res.MemPhysical >> 30), -float64(len(res.GPUs))}
+}
+
+type randomStrategy struct{}
+
+func (randomStrategy) Keys(req SwitchRequest) []string {
+	return []string{"random"}
+}
+
+func (randomStrategy) Score(req SwitchRequest, w WorkerInfo) []float64 {
+	return []float64{rand.Float64()}
+}
+
+type etaStrategy struct {
+	eta map[uuid.UUID]WorkerETA
+}
+
+func (etaStrategy) Keys(req SwitchRequest) []string {
+	key := "switchSeconds"
+	if req.Type == SwitchTypeDrain {
+		key = "stopSeconds"
+	}
+	return append([]string{"unknown", key}, defaultStrategy{}.Keys(req)...)
+}
+
+// Score 缺少任务耗时数据的worker排在后面
+func (s etaStrategy) Score(req SwitchRequest, w WorkerInfo) []float64 {
+	eta, ok := s.eta[w.WorkerID]
+	unknown := 0.0
+	if !ok || !eta.Known {
+		unknown = 1
+	}
+	d := eta.Switch
+	if req.Type == SwitchTypeDrain {
+		d = eta.Stop
+	}
+	return append([]float64{unknown, time.Duration(d).Seconds()}, defaultStrategy{}.Score(req, w)...)
+}
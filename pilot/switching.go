@@ -1,12 +1,15 @@
 package pilot
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
 	"github.com/google/uuid"
 )
 
@@ -30,15 +33,89 @@ func (s StateSwitch) String() string {
 	return stateSwitchNames[s]
 }
 
+type SwitchType string
+
+const (
+	//切换到To
+	SwitchTypeSwitch SwitchType = "switch"
+	//只停止worker，不在其他miner上启动，用于维护或下线机器
+	SwitchTypeDrain SwitchType = "drain"
+	//在新机器上启动To的worker
+	SwitchTypeAttach SwitchType = "attach"
+	//from不可用时切换到To，不等待from上的任务完成
+	SwitchTypeEvacuate SwitchType = "evacuate"
+)
+
 type SwitchRequest struct {
-	From address.Address `json:"from"`
-	To   address.Address `json:"to"`
+	//为空时默认为switch
+	Type SwitchType `json:"type"`
+	//From为空时，从FromList中的miner选择worker，FromList也为空则从所有miner(除To外)选择，此时需要指定Count或MinRemain
+	From     address.Address   `json:"from"`
+	FromList []address.Address `json:"fromList"`
+	To       address.Address   `json:"to"`
 	//如果Count为0，则切换所有worker
 	Count int `json:"count"`
 	//指定要切换的worker列表，如果为空，则由pilot选择
 	Worker []uuid.UUID `json:"worker"`
-	//切换前是否禁止AP任务，如果不禁止，则fromMiner的任务全部完成后再切到toMiner
-	DisableAP bool `json:"disableAP"`
+	//切换前禁止的任务类型，如果不禁止，则fromMiner的任务全部完成后再切到toMiner
+	//取消切换时会重新启用这些任务
+	DisableTasks []sealtasks.TaskType `json:"disableTasks"`
+	//pilot选择worker时，每个fromMiner至少保留的worker数量
+	MinRemain int `json:"minRemain"`
+	//客户端请求key，相同key和请求内容重复提交时返回已有的switch
+	Key string `json:"key"`
+	//attach的机器列表
+	Hostname []string `json:"hostname"`
+	//切换和停止条件，为空时使用fromMiner配置的策略
+	Policy *config.Policy `json:"policy"`
+	//要切换的worker角色，为空时默认为sealing
+	Role WorkerRole `json:"role"`
+	//请求来源，为空时为用户请求，autopilot等自动切换会记录来源
+	Source string `json:"source"`
+	//忽略miner的minWorkers和maxWorkers限制，只用于紧急情况
+	Override bool `json:"override"`
+	//按数量选择worker时的排序策略，为空时为default
+	Strategy string `json:"strategy"`
+	//match策略的hostname模式(例如 *-L06-*)或者标签选择器(例如 rack=L06)
+	StrategyArg string `json:"strategyArg"`
+	//只选择标签匹配的worker，例如 rack=L06,gpu=3090
+	Selector string `json:"selector"`
+	//允许切换Worker中指定的固定worker
+	Force bool `json:"force"`
+}
+
+// UnmarshalJSON 兼容旧版本的disableAP
+func (r *SwitchRequest) UnmarshalJSON(b []byte) error {
+	type request SwitchRequest
+	aux := struct {
+		*request
+		DisableAP bool `json:"disableAP"`
+	}{request: (*request)(r)}
+
+	err := json.Unmarshal(b, &aux)
+	if err != nil {
+		return err
+	}
+	if aux.DisableAP && len(r.DisableTasks) == 0 {
+		r.DisableTasks = []sealtasks.TaskType{sealtasks.TTAddPiece}
+	}
+
+	return nil
+}
+
+var ErrKeyConflict = errors.New("switch key conflict")
+
+// sameAs 比较两个请求的内容是否一致
+func (r SwitchRequest) sameAs(o SwitchRequest) bool {
+	a, err := json.Marshal(r)
+	if err != nil {
+		return false
+	}
+	b, err := json.Marshal(o)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(a, b)
 }
 
 type SwitchState struct {
@@ -47,6 +124,10 @@ type SwitchState struct {
 	ErrMsg string                     `json:"errMsg"`
 	Req    SwitchRequest              `json:"req"`
 	Worker map[uuid.UUID]*WorkerState `json:"worker"`
+	//按数量选择worker时，候选worker的顺序和分数
+	Order []PickScore `json:"order"`
+	//查询switch时计算的预计完成时间和进度，不保存
+	Progress *SwitchProgress `json:"progress,omitempty"`
 }
 
 func (s *SwitchState) update(m *Pilot) {
@@ -68,23 +149,23 @@ func (s *SwitchState) update(m *Pilot) {
 			}()
 			switch ws.State {
 			case StateWorkerPicked:
-				if s.Req.DisableAP {
-					err := disableAPCmd(m.ctx, ws.Hostname, s.Req.From.String())
+				if len(s.Req.DisableTasks) != 0 {
+					err := m.exec.disableTasks(m.ctx, ws.Hostname, ws.From.String(), s.Req.DisableTasks)
 					if err != nil {
-						log.Errorw("disableAPCmd", "switchID", s.ID, "workerID", wid, "err", err.Error())
+						log.Errorw("disableTasksCmd", "switchID", s.ID, "workerID", wid, "err", err.Error())
 						ws.updateErr(err.Error())
 						return
 					}
-					log.Debugw("disableAPCmd to confirming", "switchID", s.ID, "workerID", wid)
-					ws.State = StateWorkerDisableAPConfirming
+					log.Debugw("disableTasksCmd to confirming", "switchID", s.ID, "workerID", wid)
+					ws.State = StateWorkerDisableTasksConfirming
 				} else {
-					log.Debugw("no need disableAP to switching", "switchID", s.ID, "workerID", wid)
-					ws.State = StateWorkerSwitchWaiting
+					log.Debugw("no need disableTasks", "switchID", s.ID, "workerID", wid, "next", s.afterDisableTasks())
+					ws.State = s.afterDisableTasks()
 				}
-			case StateWorkerDisableAPConfirming:
-				worker, err := m.getWorkerStats(s.Req.From)
+			case StateWorkerDisableTasksConfirming:
+				worker, err := m.getWorkerStats(ws.From)
 				if err != nil {
-					log.Errorw("getWorkerStats", "wid", wid, "from", s.Req.From, "err", err)
+					log.Errorw("getWorkerStats", "wid", wid, "from", ws.From, "err", err)
 					return
 				}
 				w, ok := worker[wid]
@@ -96,35 +177,44 @@ func (s *SwitchState) update(m *Pilot) {
 				}
 
 				for _, t := range w.Tasks {
-					if t == sealtasks.TTAddPiece {
-						errMsg := fmt.Sprintf("DisableAPConfirming still has AP task: %s", wid)
-						log.Error(errMsg)
-						ws.updateErr(errMsg)
-						return
+					for _, dt := range s.Req.DisableTasks {
+						if t == dt {
+							errMsg := fmt.Sprintf("DisableTasksConfirming still has %s task: %s", t.Short(), wid)
+							log.Error(errMsg)
+							ws.updateErr(errMsg)
+							return
+						}
 					}
 				}
 
-				log.Infow("disableAP success", "switchID", s.ID, "workerID", ws.WorkerID, "hostname", ws.Hostname)
-				ws.State = StateWorkerSwitchWaiting
+				log.Infow("disableTasks success", "switchID", s.ID, "workerID", ws.WorkerID, "hostname", ws.Hostname, "tasks", taskShorts(s.Req.DisableTasks))
+				ws.State = s.afterDisableTasks()
 			case StateWorkerSwitchWaiting:
-				worker, err := m.getWorkerInfo(s.Req.From)
-				if err != nil {
-					log.Errorw("getWorkerInfo", "wid", wid, "from", s.Req.From, "err", err)
+				if s.Req.Type == SwitchTypeDrain {
+					ws.State = StateWorkerStopWaiting
 					return
 				}
-				w, ok := worker[wid]
-				if !ok {
-					errMsg := fmt.Sprintf("not found workerID: %s", wid)
-					log.Error(errMsg)
-					ws.updateErr(errMsg)
-					return
-				}
-				if !w.canSwitch() {
-					log.Debugw("Switching conditions not met", "switchID", s.ID, "workerID", ws.WorkerID)
-					return
+				//attach的机器上没有运行中的worker，evacuate的from不可用，直接启动
+				if s.Req.Type != SwitchTypeAttach && s.Req.Type != SwitchTypeEvacuate {
+					worker, err := m.getWorkerInfo(ws.From)
+					if err != nil {
+						log.Errorw("getWorkerInfo", "wid", wid, "from", ws.From, "err", err)
+						return
+					}
+					w, ok := worker[wid]
+					if !ok {
+						errMsg := fmt.Sprintf("not found workerID: %s", wid)
+						log.Error(errMsg)
+						ws.updateErr(errMsg)
+						return
+					}
+					if !w.canSwitch(m.policyFor(s.Req, ws.From)) {
+						log.Debugw("Switching conditions not met", "switchID", s.ID, "workerID", ws.WorkerID)
+						return
+					}
 				}
 
-				err = workerRunCmd(m.ctx, w.Hostname, s.Req.To.String(), m.repo.ScriptsPath())
+				err := m.exec.workerRun(m.ctx, ws.Hostname, s.Req.To.String(), m.repo.ScriptsPath())
 				if err != nil {
 					log.Errorw("workerRunCmd", "switchID", s.ID, "wid", wid, "to", s.Req.To, "err", err.Error())
 					ws.updateErr(err.Error())
@@ -136,62 +226,79 @@ func (s *SwitchState) update(m *Pilot) {
 			case StateWorkerSwitchConfirming:
 				worker, err := m.getWorkerStats(s.Req.To)
 				if err != nil {
-					log.Errorw("getWorkerStats", "wid", wid, "from", s.Req.From, "err", err)
+					log.Errorw("getWorkerStats", "wid", wid, "from", ws.From, "err", err)
 					return
 				}
-				has := false
-				for _, w := range worker {
-					if w.Info.Hostname == ws.Hostname {
-						has = true
-						break
-					}
-				}
-				if !has {
+				id, ok := workerByHostname(worker, ws.Hostname)
+				if !ok {
 					errMsg := fmt.Sprintf("worker: %s not found in miner: %s", ws.Hostname, s.Req.To)
 					log.Error(errMsg)
 					ws.updateErr(errMsg)
 					return
 				}
 				log.Infow("switch success", "switchID", s.ID, "workerID", ws.WorkerID, "hostname", ws.Hostname, "to", s.Req.To)
-				ws.State = StateWorkerStopWaiting
-			case StateWorkerStopWaiting:
-				worker, err := m.getWorkerInfo(s.Req.From)
-				if err != nil {
-					log.Errorw("getWorkerInfo", "wid", wid, "from", s.Req.From, "err", err)
-					return
-				}
-				w, ok := worker[wid]
-				if !ok {
-					errMsg := fmt.Sprintf("not found workerID: %s", wid)
-					log.Error(errMsg)
-					ws.updateErr(errMsg)
+				if s.Req.Type == SwitchTypeAttach {
+					//attach没有需要停止的worker，记录to上的workerID
+					ws.WorkerID = id
+					m.markAttached(ws.Hostname)
+					ws.State = StateWorkerComplete
 					return
 				}
-				if !w.canStop() {
-					log.Debugw("Stoping conditions not met", "switchID", s.ID, "workerID", ws.WorkerID)
-					return
+				ws.State = StateWorkerStopWaiting
+			case StateWorkerStopWaiting:
+				//evacuate的from不可用时，worker上的任务无法继续，直接停止，
+				//维护中的from仍然可以获取worker，按照策略等待任务完成后停止
+				if s.Req.Type != SwitchTypeEvacuate || m.isReachable(ws.From) {
+					worker, err := m.getWorkerInfo(ws.From)
+					if err != nil {
+						log.Errorw("getWorkerInfo", "wid", wid, "from", ws.From, "err", err)
+						return
+					}
+					w, ok := worker[wid]
+					if !ok {
+						errMsg := fmt.Sprintf("not found workerID: %s", wid)
+						log.Error(errMsg)
+						ws.updateErr(errMsg)
+						return
+					}
+					if !w.canStop(m.policyFor(s.Req, ws.From)) {
+						log.Debugw("Stoping conditions not met", "switchID", s.ID, "workerID", ws.WorkerID)
+						return
+					}
 				}
-				err = workerStopCmd(m.ctx, ws.Hostname, s.Req.From.String())
+				err := m.exec.workerStop(m.ctx, ws.Hostname, ws.From.String())
 				if err != nil {
-					log.Errorw("workerStopCmd", "wid", wid, "from", s.Req.From, "err", err.Error())
+					log.Errorw("workerStopCmd", "wid", wid, "from", ws.From, "err", err.Error())
 					ws.updateErr(err.Error())
 					return
 				}
-				log.Debugw("workerStopCmd", "switchID", s.ID, "workerID", ws.WorkerID, "hostname", ws.Hostname, "from", s.Req.From)
+				log.Debugw("workerStopCmd", "switchID", s.ID, "workerID", ws.WorkerID, "hostname", ws.Hostname, "from", ws.From)
 				ws.State = StateWorkerStopConfirming
 			case StateWorkerStopConfirming:
-				worker, err := m.getWorkerStats(s.Req.From)
+				if s.Req.Type == SwitchTypeEvacuate && !m.isReachable(ws.From) {
+					//from不可用，无法确认，停止命令成功即认为完成
+					log.Infow("evacuate stop success", "switchID", s.ID, "wid", ws.WorkerID, "hostname", ws.Hostname)
+					ws.State = StateWorkerComplete
+					return
+				}
+				worker, err := m.getWorkerStats(ws.From)
 				if err != nil {
-					log.Errorw("getWorkerStats", "wid", wid, "from", s.Req.From, "err", err)
+					log.Errorw("getWorkerStats", "wid", wid, "from", ws.From, "err", err)
 					return
 				}
 				if _, ok := worker[wid]; ok {
-					errMsg := fmt.Sprintf("worker: %s still in miner: %s", wid, s.Req.From)
+					errMsg := fmt.Sprintf("worker: %s still in miner: %s", wid, ws.From)
 					log.Error(errMsg)
 					ws.updateErr(errMsg)
 					return
 				}
 				log.Infow("stop success", "switchID", s.ID, "wid", ws.WorkerID, "hostname", ws.Hostname)
+				if s.Req.Type == SwitchTypeDrain {
+					err = m.markUnavailable(ws.Hostname, s.ID)
+					if err != nil {
+						log.Errorw("markUnavailable", "switchID", s.ID, "hostname", ws.Hostname, "err", err)
+					}
+				}
 				ws.State = StateWorkerComplete
 			case StateWorkerComplete:
 			case StateWorkerError:
@@ -202,6 +309,34 @@ func (s *SwitchState) update(m *Pilot) {
 	}
 	wg.Wait()
 
+	s.rekeyWorkers()
+	s.updateState()
+}
+
+// rekeyWorkers attach的worker启动后，Worker的key从选择时生成的uuid替换为to上的workerID
+func (s *SwitchState) rekeyWorkers() {
+	moved := map[uuid.UUID]*WorkerState{}
+	for wid, ws := range s.Worker {
+		if ws.WorkerID != wid {
+			moved[wid] = ws
+		}
+	}
+	for wid, ws := range moved {
+		delete(s.Worker, wid)
+		s.Worker[ws.WorkerID] = ws
+	}
+}
+
+// afterDisableTasks 禁止任务完成后的下一个状态，drain直接等待停止
+func (s *SwitchState) afterDisableTasks() StateWorker {
+	if s.Req.Type == SwitchTypeDrain {
+		return StateWorkerStopWaiting
+	}
+	return StateWorkerSwitchWaiting
+}
+
+// updateState 所有worker都完成或出错时，更新switch状态
+func (s *SwitchState) updateState() {
 	workerCompleted := 0
 	workerError := 0
 	for _, ws := range s.Worker {
@@ -224,17 +359,145 @@ func (s *SwitchState) update(m *Pilot) {
 	}
 }
 
+// check 检查请求参数
+func (r *SwitchRequest) check() error {
+	if r.Type == "" {
+		r.Type = SwitchTypeSwitch
+	}
+
+	switch r.Type {
+	case SwitchTypeSwitch:
+		if r.To.Empty() {
+			return errors.New("switch request to is empty")
+		}
+	case SwitchTypeDrain:
+		if !r.To.Empty() {
+			return errors.New("drain request should not set to")
+		}
+		if r.From.Empty() && len(r.FromList) == 0 && len(r.Worker) == 0 {
+			return errors.New("drain request need from or worker")
+		}
+	case SwitchTypeAttach:
+		if r.To.Empty() {
+			return errors.New("attach request to is empty")
+		}
+		if len(r.Hostname) == 0 {
+			return errors.New("attach request hostname is empty")
+		}
+		if !r.From.Empty() || len(r.FromList) != 0 || len(r.Worker) != 0 || r.Count != 0 || len(r.DisableTasks) != 0 || r.Selector != "" {
+			return errors.New("attach request only support to and hostname")
+		}
+	case SwitchTypeEvacuate:
+		if r.From.Empty() || r.To.Empty() {
+			return errors.New("evacuate request need from and to")
+		}
+		if r.From == r.To {
+			return errors.New("evacuate request from is same as to")
+		}
+		if len(r.FromList) != 0 || r.Count != 0 || len(r.DisableTasks) != 0 || r.Selector != "" {
+			return errors.New("evacuate request not support fromList, count, disableTasks and selector")
+		}
+	default:
+		return fmt.Errorf("unknown switch type: %s", r.Type)
+	}
+
+	for i, tt := range r.DisableTasks {
+		t, err := ParseTaskType(string(tt))
+		if err != nil {
+			return err
+		}
+		r.DisableTasks[i] = t
+	}
+
+	if r.Type != SwitchTypeAttach {
+		if r.Role == "" {
+			r.Role = RoleSealing
+		}
+		if err := checkRole(r.Role); err != nil {
+			return err
+		}
+	}
+
+	if err := checkStrategy(r); err != nil {
+		return err
+	}
+
+	if _, err := parseSelector(r.Selector); err != nil {
+		return err
+	}
+
+	if r.Policy != nil {
+		pol, err := checkPolicy(*r.Policy)
+		if err != nil {
+			return err
+		}
+		r.Policy = &pol
+	}
+
+	return nil
+}
+
+// previewPick 只选择worker不创建switch，用于查看选择策略的结果
+func (p *Pilot) previewPick(req SwitchRequest) (*SwitchState, error) {
+	err := req.check()
+	if err != nil {
+		return nil, err
+	}
+
+	worker, order, err := p.workerPick(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.checkLimits(req, worker)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SwitchState{
+		Req:    req,
+		Worker: worker,
+		Order:  order,
+	}, nil
+}
+
 func (p *Pilot) newSwitch(req SwitchRequest) (*SwitchState, error) {
-	worker, err := p.workerPick(req)
+	err := req.check()
+	if err != nil {
+		return nil, err
+	}
+
+	p.newLk.Lock()
+	defer p.newLk.Unlock()
+
+	if req.Key != "" {
+		ss := p.getSwitchByKey(req.Key)
+		if ss != nil {
+			if !ss.Req.sameAs(req) {
+				return nil, fmt.Errorf("%w: key: %s already used by switch: %s with different request", ErrKeyConflict, req.Key, ss.ID)
+			}
+			log.Infow("switch already exists", "key", req.Key, "switchID", ss.ID)
+			return ss, nil
+		}
+	}
+
+	worker, order, err := p.workerPick(req)
 	if err != nil {
 		return nil, err
 	}
 
+	err = p.checkLimits(req, worker)
+	if err != nil {
+		return nil, err
+	}
+	p.estimatePicked(req, worker)
+
 	ss := &SwitchState{
 		ID:     uuid.New(),
 		State:  StateSwitching,
 		Req:    req,
 		Worker: worker,
+		Order:  order,
 	}
 
 	err = p.addSwitch(ss)
@@ -301,16 +564,103 @@ func (p *Pilot) cancelSwitch(id uuid.UUID) error {
 	if !ok {
 		return fmt.Errorf("switchID: %s not found", id)
 	}
-	if ss.State != StateSwitching {
+	//出错的switch也可以取消，取消时重新启用出错worker上禁止的任务
+	if ss.State != StateSwitching && ss.State != StateError {
 		return fmt.Errorf("switch state: %s can not cancel", ss.State)
 	}
 
 	ss.State = StateCanceled
 	log.Infof("switch: %s canceled", ss.ID)
 
+	rollback := ss.rollbackWorkers(p.onToFunc(ss))
+	if len(rollback) != 0 {
+		go p.enableTasks(ss.ID, ss.Req.DisableTasks, rollback)
+	}
+
 	return p.writeSwitch()
 }
 
+// rollbackWorkers 已经禁止任务但还在from上运行的worker，取消时需要重新启用任务。
+// 出错的worker按出错时的状态判断，在禁止任务时出错的worker可能已经禁止了部分任务，
+// 启动后出错的worker可能已经在to上运行，onTo确认不在to上才恢复，避免同时为两个miner工作
+func (s *SwitchState) rollbackWorkers(onTo func(hostname string) bool) []WorkerState {
+	if len(s.Req.DisableTasks) == 0 {
+		return nil
+	}
+
+	var out []WorkerState
+	for _, ws := range s.Worker {
+		if ws.State == StateWorkerError {
+			switch {
+			case ws.Resume < StateWorkerSwitchConfirming:
+				out = append(out, *ws)
+			case ws.Resume > StateWorkerStopWaiting:
+				//已经停止或正在停止，不需要恢复
+			case s.Req.Type == SwitchTypeDrain:
+				//drain没有在其他miner上启动
+				out = append(out, *ws)
+			case !onTo(ws.Hostname):
+				out = append(out, *ws)
+			}
+			continue
+		}
+		switch ws.State {
+		case StateWorkerDisableTasksConfirming, StateWorkerSwitchWaiting:
+			out = append(out, *ws)
+		case StateWorkerStopWaiting:
+			//drain没有在其他miner上启动，worker需要继续工作
+			if s.Req.Type == SwitchTypeDrain {
+				out = append(out, *ws)
+			}
+		}
+	}
+
+	return out
+}
+
+// onToFunc 返回检查机器是否在switch的to上运行的函数，获取to的worker失败时认为在to上运行
+func (p *Pilot) onToFunc(s *SwitchState) func(hostname string) bool {
+	var to wst
+	var err error
+	return func(hostname string) bool {
+		if to == nil && err == nil {
+			to, err = p.workerStats(s.Req.To)
+			if err != nil {
+				log.Warnw("rollback workerStats", "switchID", s.ID, "to", s.Req.To, "err", err)
+			}
+		}
+		if err != nil {
+			return true
+		}
+		_, ok := workerByHostname(to, hostname)
+		return ok
+	}
+}
+
+func (p *Pilot) enableTasks(id uuid.UUID, tasks []sealtasks.TaskType, worker []WorkerState) {
+	var wg sync.WaitGroup
+	throttle := make(chan struct{}, p.parallel)
+
+	for _, ws := range worker {
+		wg.Add(1)
+		throttle <- struct{}{}
+		go func(ws WorkerState) {
+			defer wg.Done()
+			defer func() {
+				<-throttle
+			}()
+
+			err := p.exec.enableTasks(p.ctx, ws.Hostname, ws.From.String(), tasks)
+			if err != nil {
+				log.Errorw("enableTasksCmd", "switchID", id, "workerID", ws.WorkerID, "hostname", ws.Hostname, "err", err)
+				return
+			}
+			log.Infow("enableTasks success", "switchID", id, "workerID", ws.WorkerID, "hostname", ws.Hostname, "tasks", taskShorts(tasks))
+		}(ws)
+	}
+	wg.Wait()
+}
+
 func (p *Pilot) removeSwitch(id uuid.UUID) error {
 	p.swLk.Lock()
 	defer p.swLk.Unlock()
@@ -328,6 +678,19 @@ func (p *Pilot) getSwitch(id uuid.UUID) *SwitchState {
 	return p.switchs[id]
 }
 
+func (p *Pilot) getSwitchByKey(key string) *SwitchState {
+	p.swLk.RLock()
+	defer p.swLk.RUnlock()
+
+	for _, ss := range p.switchs {
+		if ss.Req.Key == key {
+			return ss
+		}
+	}
+
+	return nil
+}
+
 func (p *Pilot) listSwitch() []string {
 	p.swLk.RLock()
 	defer p.swLk.RUnlock()
@@ -357,6 +720,60 @@ func (p *Pilot) switchingWorkers() map[uuid.UUID]struct{} {
 	return out
 }
 
+// hasSwitchingSource 是否有source发起的进行中的切换
+func (p *Pilot) hasSwitchingSource(source string) bool {
+	p.swLk.RLock()
+	defer p.swLk.RUnlock()
+
+	for _, s := range p.switchs {
+		if s.State == StateSwitching && s.Req.Source == source {
+			return true
+		}
+	}
+
+	return false
+}
+
+// switchingMiners 进行中的切换涉及的from和to
+func (p *Pilot) switchingMiners() map[address.Address]struct{} {
+	p.swLk.RLock()
+	defer p.swLk.RUnlock()
+
+	out := map[address.Address]struct{}{}
+	for _, s := range p.switchs {
+		if s.State != StateSwitching {
+			continue
+		}
+		if !s.Req.To.Empty() {
+			out[s.Req.To] = struct{}{}
+		}
+		for _, ws := range s.Worker {
+			if !ws.From.Empty() {
+				out[ws.From] = struct{}{}
+			}
+		}
+	}
+
+	return out
+}
+
+func (p *Pilot) switchingHosts() map[string]struct{} {
+	p.swLk.RLock()
+	defer p.swLk.RUnlock()
+
+	out := map[string]struct{}{}
+
+	for _, s := range p.switchs {
+		for _, ws := range s.Worker {
+			if ws.State != StateWorkerComplete {
+				out[ws.Hostname] = struct{}{}
+			}
+		}
+	}
+
+	return out
+}
+
 func (p *Pilot) resumeSwitch(id uuid.UUID) (*SwitchState, error) {
 	p.swLk.Lock()
 	defer p.swLk.Unlock()
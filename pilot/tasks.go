@@ -0,0 +1,75 @@
+package pilot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+)
+
+// sealingTasks 可以在sealing worker上启用或禁用的任务
+var sealingTasks = []sealtasks.TaskType{
+	sealtasks.TTDataCid,
+	sealtasks.TTAddPiece,
+	sealtasks.TTPreCommit1,
+	sealtasks.TTPreCommit2,
+	sealtasks.TTCommit1,
+	sealtasks.TTCommit2,
+	sealtasks.TTFinalize,
+	sealtasks.TTFetch,
+	sealtasks.TTUnseal,
+	sealtasks.TTReplicaUpdate,
+	sealtasks.TTProveReplicaUpdate1,
+	sealtasks.TTProveReplicaUpdate2,
+	sealtasks.TTRegenSectorKey,
+	sealtasks.TTFinalizeUnsealed,
+	sealtasks.TTFinalizeReplicaUpdate,
+	sealtasks.TTDownloadSector,
+}
+
+// toggleTasks 可以通过 lotus-worker tasks enable/disable 启用或禁用的任务
+var toggleTasks = []sealtasks.TaskType{
+	sealtasks.TTDataCid,
+	sealtasks.TTAddPiece,
+	sealtasks.TTPreCommit1,
+	sealtasks.TTPreCommit2,
+	sealtasks.TTCommit2,
+	sealtasks.TTUnseal,
+	sealtasks.TTReplicaUpdate,
+	sealtasks.TTProveReplicaUpdate2,
+	sealtasks.TTRegenSectorKey,
+}
+
+// ParseTaskType 解析可以在worker上禁用的任务类型，支持短名称(AP, PC1)和完整名称(seal/v0/addpiece)
+func ParseTaskType(s string) (sealtasks.TaskType, error) {
+	for _, tt := range toggleTasks {
+		if strings.EqualFold(tt.Short(), s) || string(tt) == s {
+			return tt, nil
+		}
+	}
+
+	tt, err := parseSealingTask(s)
+	if err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("task type: %s can not be enabled or disabled by lotus-worker tasks, supported: %s", tt.Short(), strings.Join(taskShorts(toggleTasks), ", "))
+}
+
+// parseSealingTask 解析sealing worker上的任务类型，用于切换和停止条件
+func parseSealingTask(s string) (sealtasks.TaskType, error) {
+	for _, tt := range sealingTasks {
+		if strings.EqualFold(tt.Short(), s) || string(tt) == s {
+			return tt, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown task type: %s", s)
+}
+
+func taskShorts(tts []sealtasks.TaskType) []string {
+	var out []string
+	for _, tt := range tts {
+		out = append(out, tt.Short())
+	}
+	return out
+}
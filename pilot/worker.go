@@ -1,16 +1,18 @@
 package pilot
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"sort"
 	"time"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api/v0api"
 	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
 	"github.com/filecoin-project/lotus/storage/sealer/storiface"
 	"github.com/gh-efforts/lotus-pilot/build"
+	"github.com/gh-efforts/lotus-pilot/repo/config"
 	"github.com/google/uuid"
 )
 
@@ -18,15 +20,6 @@ type wst = map[uuid.UUID]storiface.WorkerStats
 type jobs = map[uuid.UUID][]storiface.WorkerJob
 type sts = map[storiface.ID][]storiface.Decl
 
-type workerInfoCache struct {
-	worker    map[uuid.UUID]WorkerInfo
-	cacheTime time.Time
-}
-type workerStatsCache struct {
-	worker    map[uuid.UUID]storiface.WorkerStats
-	cacheTime time.Time
-}
-
 type SchedInfo struct {
 	SchedInfo    SchedDiagInfo
 	ReturnedWork []string
@@ -49,7 +42,7 @@ type StateWorker int
 
 const (
 	StateWorkerPicked StateWorker = iota
-	StateWorkerDisableAPConfirming
+	StateWorkerDisableTasksConfirming
 	StateWorkerSwitchWaiting
 	StateWorkerSwitchConfirming
 	StateWorkerStopWaiting
@@ -60,14 +53,14 @@ const (
 )
 
 var stateWorkerNames = map[StateWorker]string{
-	StateWorkerPicked:              "workerPicked",
-	StateWorkerDisableAPConfirming: "workerDisableAPConfirming",
-	StateWorkerSwitchWaiting:       "workerSwitchWaiting",
-	StateWorkerSwitchConfirming:    "workerSwitchConfirming",
-	StateWorkerStopWaiting:         "workerStopWaiting",
-	StateWorkerStopConfirming:      "workerStopConfirming",
-	StateWorkerComplete:            "workeComplete",
-	StateWorkerError:               "workerError",
+	StateWorkerPicked:                 "workerPicked",
+	StateWorkerDisableTasksConfirming: "workerDisableTasksConfirming",
+	StateWorkerSwitchWaiting:          "workerSwitchWaiting",
+	StateWorkerSwitchConfirming:       "workerSwitchConfirming",
+	StateWorkerStopWaiting:            "workerStopWaiting",
+	StateWorkerStopConfirming:         "workerStopConfirming",
+	StateWorkerComplete:               "workeComplete",
+	StateWorkerError:                  "workerError",
 }
 
 func (s StateWorker) String() string {
@@ -77,25 +70,35 @@ func (s StateWorker) String() string {
 const ErrTryCount = 10
 
 type WorkerInfo struct {
-	WorkerID  uuid.UUID            `json:"workerID"`
-	StorageID storiface.ID         `json:"storageID"`
-	Hostname  string               `json:"hostname"`
-	Runing    map[string]int       `json:"runing"` //taskType
-	Prepared  map[string]int       `json:"prepared"`
-	Assigned  map[string]int       `json:"assigned"`
-	LastStart map[string]time.Time `json:"lastStart"` //last runing start time
-	Sched     map[string]int       `json:"sched"`     //task in sched
-	Sectors   map[string]struct{}  `json:"sectors"`   //sectorID
-	Tasks     map[string]struct{}  `json:"tasks"`
+	WorkerID  uuid.UUID                 `json:"workerID"`
+	StorageID storiface.ID              `json:"storageID"` //第一个封存路径
+	Hostname  string                    `json:"hostname"`
+	Runing    map[string]int            `json:"runing"` //taskType
+	Prepared  map[string]int            `json:"prepared"`
+	Assigned  map[string]int            `json:"assigned"`
+	LastStart map[string]time.Time      `json:"lastStart"` //last runing start time
+	Sched     map[string]int            `json:"sched"`     //task in sched
+	Sectors   map[string]struct{}       `json:"sectors"`   //所有封存路径上的sectorID
+	Tasks     map[string]struct{}       `json:"tasks"`
+	Roles     []WorkerRole              `json:"roles"`
+	Resources storiface.WorkerResources `json:"resources"`
+	Labels    map[string]string         `json:"labels"`
+	SealPaths []storiface.ID            `json:"sealPaths"`
+	Paths     []SealPath                `json:"paths"`
 }
 
 type WorkerState struct {
-	WorkerID uuid.UUID   `json:"workerID"`
-	Hostname string      `json:"hostname"`
-	State    StateWorker `json:"state"`
-	ErrMsg   string      `json:"errMsg"`
-	Try      int         `json:"try"`
-	Resume   StateWorker `json:"resume"`
+	WorkerID uuid.UUID       `json:"workerID"`
+	Hostname string          `json:"hostname"`
+	From     address.Address `json:"from"`
+	State    StateWorker     `json:"state"`
+	ErrMsg   string          `json:"errMsg"`
+	Try      int             `json:"try"`
+	Resume   StateWorker     `json:"resume"`
+	//选择时预计的完成时间
+	Estimate config.Duration `json:"estimate"`
+	//查询switch时计算的剩余时间，不保存
+	ETA *WorkerETA `json:"eta,omitempty"`
 }
 
 func (w *WorkerState) updateErr(errMsg string) {
@@ -111,7 +114,7 @@ func (w *WorkerState) resume() {
 	w.Try = 0
 	w.ErrMsg = ""
 	//恢复到上一个状态
-	if w.Resume == StateWorkerDisableAPConfirming || w.Resume == StateWorkerSwitchConfirming || w.Resume == StateWorkerStopConfirming {
+	if w.Resume == StateWorkerDisableTasksConfirming || w.Resume == StateWorkerSwitchConfirming || w.Resume == StateWorkerStopConfirming {
 		w.State = w.Resume - 1
 	} else {
 		w.State = w.Resume
@@ -122,162 +125,121 @@ func (w *WorkerInfo) sum(tt string) int {
 	return w.Runing[tt] + w.Prepared[tt] + w.Assigned[tt] + w.Sched[tt]
 }
 
-func (w *WorkerInfo) canSwitch() bool {
-	return w.sum("AP")+w.sum("PC1")+w.sum("PC2") == 0
-}
-
-func (w *WorkerInfo) canStop() bool {
-	var all int
-	for _, v := range w.Runing {
-		all += v
-	}
-	for _, v := range w.Prepared {
-		all += v
-	}
-	for _, v := range w.Assigned {
-		all += v
-	}
-	for _, v := range w.Sched {
-		all += v
-	}
-
-	return all+len(w.Sectors) == 0
-}
-
-func (p *Pilot) workerInfoAPI(ma address.Address) (wst, jobs, sts, SchedDiagInfo, error) {
-	p.lk.RLock()
-	defer p.lk.RUnlock()
-
-	mi, ok := p.miners[ma]
-	if !ok {
-		return nil, nil, nil, SchedDiagInfo{}, fmt.Errorf("not found miner: %s", ma)
+func minerWorkerInfo(ctx context.Context, api v0api.StorageMiner) (wst, jobs, sts, SchedDiagInfo, error) {
+	wst, err := api.WorkerStats(ctx)
+	if err != nil {
+		return nil, nil, nil, SchedDiagInfo{}, err
 	}
 
-	wst, err := mi.api.WorkerStats(p.ctx)
+	jobs, err := api.WorkerJobs(ctx)
 	if err != nil {
 		return nil, nil, nil, SchedDiagInfo{}, err
 	}
 
-	jobs, err := mi.api.WorkerJobs(p.ctx)
+	sts, err := api.StorageList(ctx)
 	if err != nil {
 		return nil, nil, nil, SchedDiagInfo{}, err
 	}
 
-	sts, err := mi.api.StorageList(p.ctx)
+	diag, err := schedDiag(ctx, api)
 	if err != nil {
 		return nil, nil, nil, SchedDiagInfo{}, err
 	}
 
+	return wst, jobs, sts, diag, nil
+}
+
+// schedDiag 获取miner调度队列中等待的任务
+func schedDiag(ctx context.Context, api v0api.StorageMiner) (SchedDiagInfo, error) {
 	if build.SkipSchedDiag {
-		return wst, jobs, sts, SchedDiagInfo{}, nil
+		return SchedDiagInfo{}, nil
 	}
 
-	schedb, err := mi.api.SealingSchedDiag(p.ctx, false)
+	schedb, err := api.SealingSchedDiag(ctx, false)
 	if err != nil {
-		return nil, nil, nil, SchedDiagInfo{}, err
+		return SchedDiagInfo{}, err
 	}
 
 	j, err := json.Marshal(&schedb)
 	if err != nil {
-		return nil, nil, nil, SchedDiagInfo{}, err
+		return SchedDiagInfo{}, err
 	}
 
 	var b SchedInfo
 	err = json.Unmarshal(j, &b)
 	if err != nil {
-		return nil, nil, nil, SchedDiagInfo{}, err
+		return SchedDiagInfo{}, err
 	}
 
 	log.Debug(b.SchedInfo)
-	return wst, jobs, sts, b.SchedInfo, nil
+	return b.SchedInfo, nil
 }
 
+// getWorkerStats 返回miner snapshot中启用的sealing worker，包含任务被禁止后暂时没有角色的worker，
+// 切换过程中按workerID查找worker使用
 func (p *Pilot) getWorkerStats(ma address.Address) (map[uuid.UUID]storiface.WorkerStats, error) {
-	p.scLk.Lock()
-	defer p.scLk.Unlock()
-
-	cache, ok := p.statsCache[ma]
-	if ok && time.Now().Before(cache.cacheTime.Add(p.cacheTimeout)) {
-		log.Debugw("getWorkerStats", "cacheTime", cache.cacheTime, "miner", ma)
-		return cache.worker, nil
-	}
-
-	worker, err := p.workerStats(ma)
+	snap, err := p.snapshot(ma)
 	if err != nil {
 		return nil, err
 	}
 
 	out := map[uuid.UUID]storiface.WorkerStats{}
-	for k, v := range worker {
-		if !workerCheck(v) {
+	for k, v := range snap.stats {
+		if !sealingWorker(v) {
 			log.Debugf("worker: %s skip", k)
 			continue
 		}
 		out[k] = v
 	}
 
-	p.statsCache[ma] = workerStatsCache{
-		worker:    out,
-		cacheTime: time.Now(),
-	}
-
 	return out, nil
 }
 
+// getWorkerInfo 返回miner snapshot中的worker信息，返回的map不能修改
 func (p *Pilot) getWorkerInfo(ma address.Address) (map[uuid.UUID]WorkerInfo, error) {
-	p.icLk.Lock()
-	defer p.icLk.Unlock()
-
-	cache, ok := p.infoCache[ma]
-	if ok && time.Now().Before(cache.cacheTime.Add(p.cacheTimeout)) {
-		log.Debugw("getWorkerInfo", "cacheTime", cache.cacheTime, "miner", ma)
-		return cache.worker, nil
-	}
-
-	worker, err := p._getWorkerInfo(ma)
+	snap, err := p.snapshot(ma)
 	if err != nil {
 		return nil, err
 	}
-	p.infoCache[ma] = workerInfoCache{
-		worker:    worker,
-		cacheTime: time.Now(),
-	}
 
-	return worker, nil
+	return snap.info, nil
 }
 
-func (p *Pilot) _getWorkerInfo(ma address.Address) (map[uuid.UUID]WorkerInfo, error) {
-	wst, jobs, sts, diag, err := p.workerInfoAPI(ma)
-	if err != nil {
-		return nil, err
-	}
-
+// buildWorkerInfo 根据WorkerStats、WorkerJobs、StorageList和调度队列统计每个worker的任务和sector，
+// sector统计worker所有封存路径
+func (p *Pilot) buildWorkerInfo(wst wst, jobs jobs, sts sts, diag SchedDiagInfo, stats map[storiface.ID]SealPath) map[uuid.UUID]WorkerInfo {
 	worker := map[uuid.UUID]WorkerInfo{}
 	sectorWorker := map[abi.SectorID]uuid.UUID{}
 	for wid, st := range wst {
-		if !workerCheck(st) {
+		if !sealingWorker(st) {
 			log.Debugf("worker: %s illegal", wid)
 			continue
 		}
 
 		var id storiface.ID
-		for _, p := range st.Paths {
-			if p.CanSeal {
-				id = p.ID
-				break
-			}
+		ids := sealPaths(st)
+		if len(ids) != 0 {
+			id = ids[0]
 		}
 
 		sectors := map[string]struct{}{}
-		for _, d := range sts[id] {
-			sectors[d.SectorID.Number.String()] = struct{}{}
-			sectorWorker[d.SectorID] = wid
+		var paths []SealPath
+		for _, pid := range ids {
+			path := stats[pid]
+			path.ID = pid
+			path.Sectors = pathSectors(sts[pid])
+			for _, d := range sts[pid] {
+				sectors[d.SectorID.Number.String()] = struct{}{}
+				sectorWorker[d.SectorID] = wid
+			}
+			paths = append(paths, path)
 		}
 
 		tasks := map[string]struct{}{}
 		for _, t := range st.Tasks {
 			tasks[t.Short()] = struct{}{}
 		}
+		roles := workerRoles(st)
 
 		worker[wid] = WorkerInfo{
 			WorkerID:  wid,
@@ -290,6 +252,11 @@ func (p *Pilot) _getWorkerInfo(ma address.Address) (map[uuid.UUID]WorkerInfo, er
 			Sched:     make(map[string]int),
 			Sectors:   sectors,
 			Tasks:     tasks,
+			Roles:     roles,
+			Resources: st.Info.Resources,
+			Labels:    p.inventory.labels(st.Info.Hostname),
+			SealPaths: ids,
+			Paths:     paths,
 		}
 	}
 
@@ -331,139 +298,351 @@ func (p *Pilot) _getWorkerInfo(ma address.Address) (map[uuid.UUID]WorkerInfo, er
 		worker[wid].Sched[req.TaskType.Short()] += 1
 	}
 
-	return worker, nil
+	return worker
 }
 
+// workerStats 返回miner snapshot中的所有worker
 func (p *Pilot) workerStats(ma address.Address) (wst, error) {
-	p.lk.RLock()
-	defer p.lk.RUnlock()
-
-	mi, ok := p.miners[ma]
-	if !ok {
-		return nil, fmt.Errorf("not found miner: %s", ma)
-	}
-
-	wst, err := mi.api.WorkerStats(p.ctx)
+	snap, err := p.snapshot(ma)
 	if err != nil {
 		return nil, err
 	}
 
-	return wst, nil
+	return snap.stats, nil
 }
 
-func (p *Pilot) workerPick(req SwitchRequest) (map[uuid.UUID]*WorkerState, error) {
+// workerPick 从req指定的fromMiner中选择要切换的worker
+// 多个fromMiner时，所有miner的worker统一排序后选择
+func (p *Pilot) workerPick(req SwitchRequest) (map[uuid.UUID]*WorkerState, []PickScore, error) {
+	if req.Type == SwitchTypeAttach {
+		worker, err := p.attachPick(req)
+		return worker, nil, err
+	}
+	if req.Type == SwitchTypeEvacuate {
+		worker, err := p.evacuatePick(req)
+		return worker, nil, err
+	}
+
 	switchingWorkers := p.switchingWorkers()
 	out := map[uuid.UUID]*WorkerState{}
 
+	sources, err := p.pickSources(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	if len(req.Worker) != 0 {
 		//specify worker from requst
-		wst, err := p.workerStats(req.From)
-		if err != nil {
-			return nil, err
-		}
 		for _, w := range req.Worker {
-			ws, ok := wst[w]
-			if !ok {
-				return nil, fmt.Errorf("worker: %s not found in wst", w)
+			from, ws, err := p.findWorker(sources, w)
+			if err != nil {
+				return nil, nil, err
 			}
 
-			if !workerCheck(ws) {
-				return nil, fmt.Errorf("specify worker: %s illegal", w)
+			if !workerCheck(ws) || !hasRole(ws, req.Role) {
+				return nil, nil, fmt.Errorf("specify worker: %s illegal for role: %s", w, req.Role)
 			}
 
 			if _, ok := switchingWorkers[w]; ok {
-				return nil, fmt.Errorf("specify worker: %s already switching", w)
+				return nil, nil, fmt.Errorf("specify worker: %s already switching", w)
+			}
+
+			if p.isUnavailable(ws.Info.Hostname) {
+				return nil, nil, fmt.Errorf("specify worker: %s host: %s unavailable", w, ws.Info.Hostname)
+			}
+
+			if !p.hostMatch(req.Selector, ws.Info.Hostname) {
+				return nil, nil, fmt.Errorf("specify worker: %s host: %s not match selector: %s", w, ws.Info.Hostname, req.Selector)
+			}
+
+			if !req.Force && p.isPinned(w, ws.Info.Hostname) {
+				return nil, nil, fmt.Errorf("specify worker: %s host: %s pinned, use force to switch", w, ws.Info.Hostname)
+			}
+
+			hw := WorkerInfo{Resources: ws.Info.Resources, SealPaths: sealPaths(ws)}
+			if err := p.checkHardware(req, from, hw); err != nil {
+				return nil, nil, fmt.Errorf("specify worker: %s host: %s incompatible with miner: %s %w", w, ws.Info.Hostname, req.To, err)
 			}
 
 			out[w] = &WorkerState{
 				WorkerID: w,
 				Hostname: ws.Info.Hostname,
+				From:     from,
 				State:    StateWorkerPicked,
 			}
 		}
-		return out, nil
+		return out, nil, nil
 	}
 
-	if req.Count == 0 {
+	if req.Count == 0 && req.MinRemain == 0 {
 		//switch all worker
-		wst, err := p.workerStats(req.From)
+		outgoing, _ := p.inflightWorkers()
+		for _, from := range sources {
+			wst, err := p.workerStats(from)
+			if err != nil {
+				return nil, nil, err
+			}
+			//limit为负数时不限制，否则为这个miner最多可以选走的worker数量
+			limit := -1
+			minWorkers, _ := p.minerLimits(from)
+			if minWorkers != 0 && !req.Override {
+				legal := 0
+				for _, st := range wst {
+					if workerCheck(st) {
+						legal += 1
+					}
+				}
+				limit = max(legal-outgoing[from]-minWorkers, 0)
+			}
+			picked := 0
+			for wid, st := range wst {
+				if limit >= 0 && picked >= limit {
+					log.Warnw("reach miner min workers, skip remaining workers", "miner", from, "minWorkers", minWorkers, "picked", picked)
+					break
+				}
+				if !workerCheck(st) || !hasRole(st, req.Role) {
+					continue
+				}
+				if _, ok := switchingWorkers[wid]; ok {
+					continue
+				}
+				if p.isUnavailable(st.Info.Hostname) {
+					continue
+				}
+				if !p.hostMatch(req.Selector, st.Info.Hostname) {
+					continue
+				}
+				if p.isPinned(wid, st.Info.Hostname) {
+					continue
+				}
+				hw := WorkerInfo{Resources: st.Info.Resources, SealPaths: sealPaths(st)}
+				if err := p.checkHardware(req, from, hw); err != nil {
+					log.Warnw("skip incompatible worker", "workerID", wid, "hostname", st.Info.Hostname, "to", req.To, "err", err)
+					continue
+				}
+				out[wid] = &WorkerState{
+					WorkerID: wid,
+					Hostname: st.Info.Hostname,
+					From:     from,
+					State:    StateWorkerPicked,
+				}
+				picked += 1
+			}
+		}
+		return out, nil, nil
+	}
+
+	outgoing, _ := p.inflightWorkers()
+	//每个miner还可以被选走的worker数量
+	quota := map[address.Address]int{}
+	var workerSort []pickCandidate
+	total := 0
+	for _, from := range sources {
+		worker, err := p.getWorkerInfo(from)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		for wid, st := range wst {
-			if !workerCheck(st) {
+		remain := 0
+		//pilot管理的所有角色的worker，不包含miner本地的worker
+		managed := 0
+		for _, w := range worker {
+			if len(w.Roles) != 0 {
+				managed += 1
+			}
+			if !w.hasRole(req.Role) {
 				continue
 			}
-			if _, ok := switchingWorkers[wid]; ok {
+			total += 1
+			remain += 1
+
+			//skip switchingWorkers
+			if _, ok := switchingWorkers[w.WorkerID]; ok {
+				remain -= 1
 				continue
 			}
-			out[wid] = &WorkerState{
-				WorkerID: wid,
-				Hostname: st.Info.Hostname,
-				State:    StateWorkerPicked,
+			if p.isUnavailable(w.Hostname) {
+				continue
+			}
+			if !p.hostMatch(req.Selector, w.Hostname) {
+				continue
+			}
+			if p.isPinned(w.WorkerID, w.Hostname) {
+				continue
 			}
-		}
-		return out, nil
-	}
 
-	worker, err := p._getWorkerInfo(req.From)
-	if err != nil {
-		return nil, err
+			c := pickCandidate{from: from, WorkerInfo: w}
+			if err := p.checkHardware(req, from, w); err != nil {
+				c.skip = fmt.Sprintf("incompatible: %s", err)
+			}
+			workerSort = append(workerSort, c)
+		}
+		quota[from] = remain - req.MinRemain
+		if minWorkers, _ := p.minerLimits(from); minWorkers != 0 && !req.Override {
+			//worker包含所有角色，minWorkers按所有worker计算
+			quota[from] = min(quota[from], managed-outgoing[from]-minWorkers)
+		}
 	}
 
-	if len(worker) < req.Count {
-		return nil, fmt.Errorf("not enough worker. miner: %s has: %d need: %d", req.From, len(worker), req.Count)
+	if len(sources) == 1 && total < req.Count {
+		return nil, nil, fmt.Errorf("not enough worker. miner: %s has: %d need: %d", sources[0], total, req.Count)
 	}
 
-	var workerSort []WorkerInfo
-	for _, w := range worker {
-		//skip switchingWorkers
-		if _, ok := switchingWorkers[w.WorkerID]; ok {
+	order := scoreCandidates(req, p.pickStrategy(req, workerSort), workerSort)
+	for i := range order {
+		c := &order[i]
+		if c.Skip != "" {
+			continue
+		}
+		if req.Count != 0 && len(out) == req.Count {
+			c.Skip = "count reached"
 			continue
 		}
+		if quota[c.From] <= 0 {
+			c.Skip = "miner reached minRemain or minWorkers"
+			continue
+		}
+		quota[c.From] -= 1
 
-		workerSort = append(workerSort, w)
+		c.Picked = true
+		out[c.WorkerID] = &WorkerState{
+			WorkerID: c.WorkerID,
+			Hostname: c.Hostname,
+			From:     c.From,
+			State:    StateWorkerPicked,
+		}
 	}
 
-	if len(workerSort) < req.Count {
-		return nil, fmt.Errorf("not enough worker. miner: %s has(remove switching): %d need: %d", req.From, len(workerSort), req.Count)
+	if len(out) < req.Count {
+		return nil, nil, fmt.Errorf("not enough worker. miner: %v has(remove switching, minRemain: %d and minWorkers): %d need: %d", sources, req.MinRemain, len(out), req.Count)
+	}
+	if len(out) == 0 {
+		return nil, nil, fmt.Errorf("no worker can be picked from miner: %v", sources)
 	}
 
-	sort.Slice(workerSort, func(i, j int) bool {
-		wi := workerSort[i]
-		wj := workerSort[j]
+	return out, order, nil
+}
 
-		if wi.sum("AP")+wi.sum("PC1") != wj.sum("AP")+wj.sum("PC1") {
-			return wi.sum("AP")+wi.sum("PC1") < wj.sum("AP")+wj.sum("PC1")
+// attachPick 检查attach的机器没有在任何miner上运行
+// 机器上还没有worker，使用随机的workerID，attach成功后更新为to上的workerID
+func (p *Pilot) attachPick(req SwitchRequest) (map[uuid.UUID]*WorkerState, error) {
+	if !p.hasMiner(req.To) {
+		return nil, fmt.Errorf("not found miner: %s", req.To)
+	}
+
+	switchingHosts := p.switchingHosts()
+	running := map[string]address.Address{}
+	for _, miner := range p.minerList() {
+		//获取失败的miner不影响attach到其他miner，只能检查其他miner上是否已经运行
+		wst, err := p.workerStats(miner)
+		if err != nil {
+			log.Warnw("attachPick skip miner", "miner", miner, "err", err)
+			continue
 		}
+		for _, st := range wst {
+			running[st.Info.Hostname] = miner
+		}
+	}
 
-		if wi.sum("PC2") != wj.sum("PC2") {
-			return wi.sum("PC2") < wj.sum("PC2")
+	out := map[uuid.UUID]*WorkerState{}
+	picked := map[string]struct{}{}
+	for _, hostname := range req.Hostname {
+		if _, ok := picked[hostname]; ok {
+			continue
 		}
+		picked[hostname] = struct{}{}
 
-		if !wi.LastStart["PC1"].Equal(wj.LastStart["PC1"]) {
-			return wi.LastStart["PC1"].Before(wj.LastStart["PC1"])
+		if _, ok := switchingHosts[hostname]; ok {
+			return nil, fmt.Errorf("host: %s already switching", hostname)
+		}
+		if miner, ok := running[hostname]; ok {
+			return nil, fmt.Errorf("host: %s already running in miner: %s", hostname, miner)
 		}
 
-		if len(wi.Sectors) != len(wj.Sectors) {
-			return len(wi.Sectors) < len(wj.Sectors)
+		wid := uuid.New()
+		out[wid] = &WorkerState{
+			WorkerID: wid,
+			Hostname: hostname,
+			State:    StateWorkerPicked,
 		}
+	}
+
+	return out, nil
+}
 
-		return wi.Hostname < wj.Hostname
-	})
+type pickCandidate struct {
+	from address.Address
+	WorkerInfo
+	//不能被选择的原因
+	skip string
+}
 
-	for _, w := range workerSort[0:req.Count] {
-		out[w.WorkerID] = &WorkerState{
-			WorkerID: w.WorkerID,
-			Hostname: w.Hostname,
-			State:    StateWorkerPicked,
+// pickSources 返回可以选择worker的fromMiner列表
+func (p *Pilot) pickSources(req SwitchRequest) ([]address.Address, error) {
+	if !req.From.Empty() {
+		return []address.Address{req.From}, nil
+	}
+
+	p.lk.RLock()
+	defer p.lk.RUnlock()
+
+	var out []address.Address
+	if len(req.FromList) != 0 {
+		for _, from := range req.FromList {
+			if _, ok := p.miners[from]; !ok {
+				return nil, fmt.Errorf("not found miner: %s", from)
+			}
+			if from == req.To {
+				return nil, fmt.Errorf("from miner: %s is same as to miner", from)
+			}
+			out = append(out, from)
 		}
+		return out, nil
+	}
+
+	//不指定from时不能切换所有miner的所有worker
+	if len(req.Worker) == 0 && req.Count == 0 && req.MinRemain == 0 {
+		return nil, fmt.Errorf("pick worker from all miners need count or minRemain")
+	}
+	for miner := range p.miners {
+		if miner == req.To {
+			continue
+		}
+		out = append(out, miner)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no miner to pick worker except to miner: %s", req.To)
 	}
 
 	return out, nil
 }
 
+// findWorker 在sources中查找指定的worker
+func (p *Pilot) findWorker(sources []address.Address, wid uuid.UUID) (address.Address, storiface.WorkerStats, error) {
+	for _, from := range sources {
+		wst, err := p.workerStats(from)
+		if err != nil {
+			return address.Undef, storiface.WorkerStats{}, err
+		}
+		if ws, ok := wst[wid]; ok {
+			return from, ws, nil
+		}
+	}
+
+	return address.Undef, storiface.WorkerStats{}, fmt.Errorf("worker: %s not found in wst", wid)
+}
+
+// workerCheck 只管理启用的、至少有一个角色的sealing worker，miner本地的worker没有角色，不会被管理
 func workerCheck(st storiface.WorkerStats) bool {
+	if !sealingWorker(st) {
+		return false
+	}
+
+	//skip miner local worker
+	return len(workerRoles(st)) != 0
+}
+
+// sealingWorker 启用的sealing worker，任务被禁止后worker可能暂时没有任何角色
+func sealingWorker(st storiface.WorkerStats) bool {
 	//skip winPost worker
 	if len(st.Tasks) > 0 {
 		if st.Tasks[0].WorkerType() != sealtasks.WorkerSealing {
@@ -476,20 +655,5 @@ func workerCheck(st storiface.WorkerStats) bool {
 		return false
 	}
 
-	//skip miner local worker
-	enablePC1 := false
-	enablePC2 := false
-	for _, t := range st.Tasks {
-		if t == sealtasks.TTPreCommit1 {
-			enablePC1 = true
-		}
-		if t == sealtasks.TTPreCommit2 {
-			enablePC2 = true
-		}
-	}
-	if !enablePC1 && !enablePC2 {
-		return false
-	}
-
 	return true
 }
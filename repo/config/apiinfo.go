@@ -0,0 +1,218 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Endpoint 解析后的miner API地址
+type Endpoint struct {
+	Token string
+	//host:port，ipv6带[]
+	Host string
+	TLS  bool
+}
+
+// Parse 解析miner API信息。Info不为空时使用Info，格式同lotus的API信息：
+// TOKEN:/ip4|ip6|dns|dns4|dns6/<host>/tcp/<port>/http|https|ws|wss 或 TOKEN:http(s)|ws(s)://host:port，
+// 否则使用Addr(host:port)和Token
+func (a *APIInfo) Parse() (Endpoint, error) {
+	if a.Info == "" {
+		if a.Addr == "" || a.Token == "" {
+			return Endpoint{}, fmt.Errorf("api info is empty")
+		}
+		host, port, err := net.SplitHostPort(a.Addr)
+		if err != nil {
+			return Endpoint{}, fmt.Errorf("addr: %s %w", a.Addr, err)
+		}
+		if err := checkPort(port); err != nil {
+			return Endpoint{}, err
+		}
+		return Endpoint{Token: a.Token, Host: net.JoinHostPort(host, port)}, nil
+	}
+
+	token, addr, ok := splitAPIInfo(a.Info)
+	if !ok || token == "" || addr == "" {
+		return Endpoint{}, fmt.Errorf("api info should be TOKEN:ADDR")
+	}
+	if strings.HasPrefix(addr, "/") {
+		ep, err := parseMultiaddr(addr)
+		if err != nil {
+			return Endpoint{}, err
+		}
+		ep.Token = token
+		return ep, nil
+	}
+	ep, err := parseURL(addr)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	ep.Token = token
+	return ep, nil
+}
+
+// tokenRefs token引用的前缀，见repo.ResolveToken
+var tokenRefs = []string{"env:", "file:", "keystore:"}
+
+// IsTokenRef token是否为引用
+func IsTokenRef(token string) bool {
+	for _, ref := range tokenRefs {
+		if strings.HasPrefix(token, ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitAPIInfo 分开token和地址，token可以是引用，例如 env:MINER_TOKEN:/ip4/...
+func splitAPIInfo(info string) (string, string, bool) {
+	for _, ref := range tokenRefs {
+		if rest, ok := strings.CutPrefix(info, ref); ok {
+			name, addr, ok := strings.Cut(rest, ":")
+			return ref + name, addr, ok
+		}
+	}
+	return strings.Cut(info, ":")
+}
+
+// RedactToken 用于日志和接口返回，token引用原样返回，token本身替换为***
+func RedactToken(token string) string {
+	if token == "" || IsTokenRef(token) {
+		return token
+	}
+	return "***"
+}
+
+// RedactAPIInfo 替换API信息中的token
+func RedactAPIInfo(info string) string {
+	token, addr, ok := splitAPIInfo(info)
+	if !ok || strings.HasPrefix(token, "$(") {
+		return info
+	}
+	return RedactToken(token) + ":" + addr
+}
+
+// String 打印配置时不输出token
+func (a APIInfo) String() string {
+	a.Token = RedactToken(a.Token)
+	if a.Info != "" {
+		a.Info = RedactAPIInfo(a.Info)
+	}
+	type plain APIInfo
+	return fmt.Sprintf("%+v", plain(a))
+}
+
+func parseMultiaddr(addr string) (Endpoint, error) {
+	parts := strings.Split(strings.TrimPrefix(addr, "/"), "/")
+	if len(parts) < 4 || parts[2] != "tcp" {
+		return Endpoint{}, fmt.Errorf("multiaddr: %s should be /<ip4|ip6|dns>/<host>/tcp/<port>[/http]", addr)
+	}
+
+	host := parts[1]
+	switch parts[0] {
+	case "ip4":
+		ip := net.ParseIP(host)
+		if ip == nil || ip.To4() == nil {
+			return Endpoint{}, fmt.Errorf("multiaddr: %s illegal ip4: %s", addr, host)
+		}
+	case "ip6":
+		ip := net.ParseIP(host)
+		if ip == nil || ip.To4() != nil {
+			return Endpoint{}, fmt.Errorf("multiaddr: %s illegal ip6: %s", addr, host)
+		}
+	case "dns", "dns4", "dns6":
+		if host == "" {
+			return Endpoint{}, fmt.Errorf("multiaddr: %s dns is empty", addr)
+		}
+	default:
+		return Endpoint{}, fmt.Errorf("multiaddr: %s unsupported protocol: %s", addr, parts[0])
+	}
+	if err := checkPort(parts[3]); err != nil {
+		return Endpoint{}, err
+	}
+
+	ep := Endpoint{Host: net.JoinHostPort(host, parts[3])}
+	//tls/http 和 https 相同
+	rest := parts[4:]
+	if len(rest) != 0 && rest[0] == "tls" {
+		ep.TLS = true
+		rest = rest[1:]
+	}
+	switch strings.Join(rest, "/") {
+	case "", "http", "ws":
+	case "https", "wss":
+		ep.TLS = true
+	default:
+		return Endpoint{}, fmt.Errorf("multiaddr: %s unsupported protocol: %s", addr, strings.Join(rest, "/"))
+	}
+	return ep, nil
+}
+
+func parseURL(addr string) (Endpoint, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return Endpoint{}, err
+	}
+
+	ep := Endpoint{}
+	port := "80"
+	switch u.Scheme {
+	case "http", "ws":
+	case "https", "wss":
+		ep.TLS = true
+		port = "443"
+	default:
+		return Endpoint{}, fmt.Errorf("url: %s unsupported scheme: %s", addr, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return Endpoint{}, fmt.Errorf("url: %s host is empty", addr)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	if err := checkPort(port); err != nil {
+		return Endpoint{}, err
+	}
+	ep.Host = net.JoinHostPort(u.Hostname(), port)
+	return ep, nil
+}
+
+func checkPort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("illegal port: %s", port)
+	}
+	return nil
+}
+
+func (e Endpoint) String() string {
+	return RedactToken(e.Token) + "@" + e.URL()
+}
+
+// URL pilot连接miner使用的地址，TLS时使用https，否则使用websocket
+func (e Endpoint) URL() string {
+	if e.TLS {
+		return "https://" + e.Host + "/rpc/v0"
+	}
+	return "ws://" + e.Host + "/rpc/v0"
+}
+
+// APIInfo 生成worker使用的MINER_API_INFO，lotus对multiaddr只使用ws，TLS时使用wss的url
+func (e Endpoint) APIInfo() string {
+	if e.TLS {
+		return fmt.Sprintf("%s:wss://%s", e.Token, e.Host)
+	}
+
+	host, port, _ := net.SplitHostPort(e.Host)
+	proto := "dns"
+	if ip := net.ParseIP(host); ip != nil {
+		proto = "ip6"
+		if ip.To4() != nil {
+			proto = "ip4"
+		}
+	}
+	return fmt.Sprintf("%s:/%s/%s/tcp/%s/http", e.Token, proto, host, port)
+}
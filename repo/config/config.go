@@ -2,9 +2,7 @@ package config
 
 import (
 	"encoding/json"
-	"fmt"
 	"os"
-	"strings"
 	"time"
 )
 
@@ -31,21 +29,146 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 type APIInfo struct {
 	Addr  string `json:"addr"`
 	Token string `json:"token"`
+	//lotus格式的API信息，TOKEN:/ip4/127.0.0.1/tcp/2345/http，不为空时忽略Addr和Token
+	Info string `json:"apiInfo,omitempty"`
+	//TLS时校验miner证书的CA文件，为空时使用系统CA
+	CAFile string `json:"caFile,omitempty"`
+	//miner至少保留的worker数量，切换不能使worker数量低于它
+	MinWorkers int `json:"minWorkers"`
+	//miner最多的worker数量，为0时不限制
+	MaxWorkers int `json:"maxWorkers"`
 }
 
 func (a *APIInfo) ToAPIInfo() string {
-	ss := strings.Split(a.Addr, ":")
-	if len(ss) != 2 {
+	ep, err := a.Parse()
+	if err != nil {
 		return ""
 	}
-	return fmt.Sprintf("%s:/ip4/%s/tcp/%s/http", a.Token, ss[0], ss[1])
+	return ep.APIInfo()
+}
+
+// Policy worker切换和停止的条件，任务类型使用短名称(AP, PC1, RU...)
+type Policy struct {
+	//切换到to前，worker上需要完成的任务
+	SwitchTasks []string `json:"switchTasks"`
+	//停止前，worker上需要完成的任务
+	StopTasks []string `json:"stopTasks"`
+	//停止前需要完成所有任务，为true时忽略StopTasks
+	StopAllTasks bool `json:"stopAllTasks"`
+	//停止前worker上不能有sector
+	StopNoSector bool `json:"stopNoSector"`
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		SwitchTasks:  []string{"AP", "PC1", "PC2"},
+		StopAllTasks: true,
+		StopNoSector: true,
+	}
+}
+
+// Backlog backlog模式的阈值。积压为调度队列中等待的sector加上处于WaitDeals等状态的sector，
+// 等待的订单只按sector状态统计，market中还没有分配到sector的订单不计入
+type Backlog struct {
+	//积压sector数量不超过IdleSectors时认为pipeline为空，可以切出worker
+	IdleSectors int `json:"idleSectors"`
+	//积压sector数量不少于BusySectors时需要更多worker
+	BusySectors int `json:"busySectors"`
+	//每个worker可以处理的积压sector数量，用于计算需要的worker数量
+	SectorsPerWorker int `json:"sectorsPerWorker"`
+	//pipeline为空的miner至少保留的worker数量
+	MinWorkers int `json:"minWorkers"`
+}
+
+// Quota quota模式的每日封存配额
+type Quota struct {
+	//每个miner每天封存的sector数量
+	Sectors map[string]int `json:"sectors"`
+	//根据最近Window内完成的PC1估算每个worker的产能
+	Window Duration `json:"window"`
+}
+
+// Autopilot 自动在miner之间切换worker
+// ratio模式按目标比例平衡，backlog模式按miner的封存积压平衡，quota模式按每日配额平衡
+type Autopilot struct {
+	Enable bool `json:"enable"`
+	//ratio, backlog或quota，为空时为ratio
+	Mode string `json:"mode"`
+	//只打印计划的切换，不执行
+	DryRun   bool     `json:"dryRun"`
+	Interval Duration `json:"interval"`
+	//每个miner的目标worker比例，例如 50/30/20
+	Targets map[string]int `json:"targets"`
+	//miner的worker数量与目标相差超过Hysteresis时才切换
+	Hysteresis int `json:"hysteresis"`
+	//每小时最多切换的worker数量，开启时必须大于0
+	MaxMovesPerHour int `json:"maxMovesPerHour"`
+	//要平衡的worker角色，为空时默认为sealing
+	Role string `json:"role"`
+	//切换前禁止的任务
+	DisableTasks []string `json:"disableTasks"`
+	Backlog      Backlog  `json:"backlog"`
+	Quota        Quota    `json:"quota"`
+}
+
+// Evacuation miner不健康时自动把worker切换到备用miner
+type Evacuation struct {
+	Enable bool `json:"enable"`
+	//连续RPC失败MaxFailures次认为miner不健康
+	MaxFailures int `json:"maxFailures"`
+	//miner不健康超过Grace后开始撤离
+	Grace Duration `json:"grace"`
+	//每个miner的备用miner，没有配置的miner不会撤离
+	Fallback map[string]string `json:"fallback"`
+	//miner恢复后自动把撤离的worker切回
+	SwitchBack bool `json:"switchBack"`
+}
+
+// Recorder 定期保存miner的原始worker数据，用于lotus-pilot simulate
+type Recorder struct {
+	Enable   bool     `json:"enable"`
+	Interval Duration `json:"interval"`
+	//只保留最近Retention的记录
+	Retention Duration `json:"retention"`
+}
+
+// Hardware 切换到某个sector大小的miner时worker需要的最低配置，0为不检查
+type Hardware struct {
+	MemGiB int `json:"memGiB"`
+	CPUs   int `json:"cpus"`
+	GPUs   int `json:"gpus"`
+	//所有封存路径的总容量，需要满足模板中PC1的并行数量
+	SealGiB int `json:"sealGiB"`
+}
+
+// HostLabels 给机器添加标签，Hostname可以是hostname或者模式(例如 *-L06-*)
+type HostLabels struct {
+	Hostname string            `json:"hostname"`
+	Labels   map[string]string `json:"labels"`
 }
 
 type Config struct {
-	Interval     Duration           `json:"interval"`
-	CacheTimeout Duration           `json:"cacheTimeout"`
-	Parallel     int                `json:"parallel"`
-	Miners       map[string]APIInfo `json:"miners"`
+	Interval     Duration `json:"interval"`
+	CacheTimeout Duration `json:"cacheTimeout"`
+	//后台获取所有miner worker数据的间隔，为0时使用CacheTimeout
+	CollectInterval Duration `json:"collectInterval"`
+	//每个miner API调用的超时，为0时不限制
+	RPCTimeout Duration `json:"rpcTimeout"`
+	//探测miner API连接的间隔，失败时按退避时间重连，为0时不探测
+	ProbeInterval Duration `json:"probeInterval"`
+	Parallel      int      `json:"parallel"`
+	//worker机器上保存miner API信息的目录，不为空时脚本从这个目录中的 <miner>.api 读取MINER_API_INFO，脚本中没有token
+	APIInfoDir string             `json:"apiInfoDir,omitempty"`
+	Miners     map[string]APIInfo `json:"miners"`
+	//每个miner的切换条件，没有配置的miner使用默认条件
+	Policies   map[string]Policy `json:"policies"`
+	Autopilot  Autopilot         `json:"autopilot"`
+	Evacuation Evacuation        `json:"evacuation"`
+	Recorder   Recorder          `json:"recorder"`
+	//机器标签，多个匹配时后面的覆盖前面的
+	Hosts []HostLabels `json:"hosts"`
+	//key为sector大小(32GiB, 64GiB)，切换时检查worker是否满足to的要求
+	Hardware map[string]Hardware `json:"hardware"`
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -78,9 +201,51 @@ func DefaultConfig() *Config {
 	miners["t028064"] = miner64
 
 	return &Config{
-		Interval:     Duration(time.Minute),
-		CacheTimeout: Duration(time.Second * 30),
-		Parallel:     10,
-		Miners:       miners,
+		Interval:        Duration(time.Minute),
+		CacheTimeout:    Duration(time.Second * 30),
+		CollectInterval: Duration(time.Second * 20),
+		RPCTimeout:      Duration(time.Second * 30),
+		ProbeInterval:   Duration(time.Second * 30),
+		Parallel:        10,
+		Miners:          miners,
+		Autopilot: Autopilot{
+			Enable:          false,
+			Mode:            "ratio",
+			DryRun:          true,
+			Interval:        Duration(time.Minute * 10),
+			Targets:         map[string]int{"t017387": 50, "t028064": 50},
+			Hysteresis:      2,
+			MaxMovesPerHour: 10,
+			Role:            "sealing",
+			Backlog: Backlog{
+				IdleSectors:      0,
+				BusySectors:      24,
+				SectorsPerWorker: 24,
+				MinWorkers:       1,
+			},
+			Quota: Quota{
+				Sectors: map[string]int{"t017387": 1000, "t028064": 500},
+				Window:  Duration(time.Hour * 6),
+			},
+		},
+		Evacuation: Evacuation{
+			Enable:      false,
+			MaxFailures: 3,
+			Grace:       Duration(time.Minute * 30),
+			Fallback:    map[string]string{},
+			SwitchBack:  false,
+		},
+		Recorder: Recorder{
+			Enable:    false,
+			Interval:  Duration(time.Minute * 5),
+			Retention: Duration(time.Hour * 24 * 7),
+		},
+		Hosts: []HostLabels{
+			{Hostname: "*-L06-*", Labels: map[string]string{"rack": "L06"}},
+		},
+		Hardware: map[string]Hardware{
+			//worker64G.tmpl PC1_64G_MAX_CONCURRENT=14
+			"64GiB": {MemGiB: 1000, CPUs: 96, GPUs: 1, SealGiB: 14 * 900},
+		},
 	}
 }
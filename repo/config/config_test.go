@@ -2,7 +2,9 @@ package config_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/gh-efforts/lotus-pilot/repo/config"
@@ -27,3 +29,131 @@ func TestLoadConfig(t *testing.T) {
 	}
 	t.Log(conf)
 }
+
+func TestParseAPIInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		info config.APIInfo
+		host string
+		tls  bool
+		err  bool
+	}{
+		{name: "addr", info: config.APIInfo{Addr: "127.0.0.1:2345", Token: "tok"}, host: "127.0.0.1:2345"},
+		{name: "addr bad port", info: config.APIInfo{Addr: "127.0.0.1:0", Token: "tok"}, err: true},
+		{name: "addr without token", info: config.APIInfo{Addr: "127.0.0.1:2345"}, err: true},
+		{name: "ip4", info: config.APIInfo{Info: "tok:/ip4/10.0.0.1/tcp/2345/http"}, host: "10.0.0.1:2345"},
+		{name: "ip4 without protocol", info: config.APIInfo{Info: "tok:/ip4/10.0.0.1/tcp/2345"}, host: "10.0.0.1:2345"},
+		{name: "ip4 ws", info: config.APIInfo{Info: "tok:/ip4/10.0.0.1/tcp/2345/ws"}, host: "10.0.0.1:2345"},
+		{name: "ip6", info: config.APIInfo{Info: "tok:/ip6/::1/tcp/2345/http"}, host: "[::1]:2345"},
+		{name: "dns", info: config.APIInfo{Info: "tok:/dns/miner.example.com/tcp/2345/http"}, host: "miner.example.com:2345"},
+		{name: "dns4", info: config.APIInfo{Info: "tok:/dns4/miner.example.com/tcp/2345/http"}, host: "miner.example.com:2345"},
+		{name: "tls http", info: config.APIInfo{Info: "tok:/dns/miner.example.com/tcp/2345/tls/http"}, host: "miner.example.com:2345", tls: true},
+		{name: "https", info: config.APIInfo{Info: "tok:/ip4/10.0.0.1/tcp/2345/https"}, host: "10.0.0.1:2345", tls: true},
+		{name: "wss", info: config.APIInfo{Info: "tok:/ip6/::1/tcp/2345/wss"}, host: "[::1]:2345", tls: true},
+		{name: "ip4 illegal", info: config.APIInfo{Info: "tok:/ip4/::1/tcp/2345/http"}, err: true},
+		{name: "ip6 illegal", info: config.APIInfo{Info: "tok:/ip6/10.0.0.1/tcp/2345/http"}, err: true},
+		{name: "udp", info: config.APIInfo{Info: "tok:/ip4/10.0.0.1/udp/2345/http"}, err: true},
+		{name: "unsupported protocol", info: config.APIInfo{Info: "tok:/ip4/10.0.0.1/tcp/2345/quic"}, err: true},
+		{name: "multiaddr bad port", info: config.APIInfo{Info: "tok:/ip4/10.0.0.1/tcp/65536/http"}, err: true},
+		{name: "multiaddr port not number", info: config.APIInfo{Info: "tok:/ip4/10.0.0.1/tcp/abc/http"}, err: true},
+		{name: "url http without port", info: config.APIInfo{Info: "tok:http://miner.example.com"}, host: "miner.example.com:80"},
+		{name: "url https without port", info: config.APIInfo{Info: "tok:https://miner.example.com"}, host: "miner.example.com:443", tls: true},
+		{name: "url ws with port", info: config.APIInfo{Info: "tok:ws://10.0.0.1:2345"}, host: "10.0.0.1:2345"},
+		{name: "url wss with port", info: config.APIInfo{Info: "tok:wss://[::1]:2345"}, host: "[::1]:2345", tls: true},
+		{name: "url bad port", info: config.APIInfo{Info: "tok:http://miner.example.com:0"}, err: true},
+		{name: "url unsupported scheme", info: config.APIInfo{Info: "tok:tcp://miner.example.com:2345"}, err: true},
+		{name: "url without host", info: config.APIInfo{Info: "tok:http://:2345"}, err: true},
+		{name: "without token", info: config.APIInfo{Info: ":/ip4/10.0.0.1/tcp/2345/http"}, err: true},
+		{name: "without addr", info: config.APIInfo{Info: "tok"}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ep, err := tt.info.Parse()
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expect error, got: %+v", ep)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ep.Token != "tok" || ep.Host != tt.host || ep.TLS != tt.tls {
+				t.Fatalf("got: %+v, expect host: %s tls: %v", ep, tt.host, tt.tls)
+			}
+
+			//worker使用的API信息可以重新解析为相同的地址
+			rt, err := (&config.APIInfo{Info: ep.APIInfo()}).Parse()
+			if err != nil {
+				t.Fatalf("parse %s: %s", ep.APIInfo(), err)
+			}
+			if rt != ep {
+				t.Fatalf("round trip %s got: %+v, expect: %+v", ep.APIInfo(), rt, ep)
+			}
+		})
+	}
+}
+
+func TestTokenRef(t *testing.T) {
+	tests := []struct {
+		info  string
+		token string
+		host  string
+	}{
+		{info: "env:MINER_TOKEN:/ip4/10.0.0.1/tcp/2345/http", token: "env:MINER_TOKEN", host: "10.0.0.1:2345"},
+		{info: "file:/etc/miner/token:/ip4/10.0.0.1/tcp/2345/http", token: "file:/etc/miner/token", host: "10.0.0.1:2345"},
+		{info: "keystore:t017387:wss://miner.example.com:2345", token: "keystore:t017387", host: "miner.example.com:2345"},
+	}
+
+	for _, tt := range tests {
+		ep, err := (&config.APIInfo{Info: tt.info}).Parse()
+		if err != nil {
+			t.Fatalf("parse %s: %s", tt.info, err)
+		}
+		if ep.Token != tt.token || ep.Host != tt.host {
+			t.Fatalf("parse %s got: %+v", tt.info, ep)
+		}
+		if !config.IsTokenRef(ep.Token) {
+			t.Fatalf("%s should be token ref", ep.Token)
+		}
+		//token引用不是敏感信息，原样输出
+		if r := config.RedactAPIInfo(tt.info); r != tt.info {
+			t.Fatalf("redact %s got: %s", tt.info, r)
+		}
+	}
+}
+
+func TestRedactToken(t *testing.T) {
+	const secret = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.secret"
+
+	info := config.APIInfo{Addr: "10.0.0.1:2345", Token: secret}
+	if s := info.String(); strings.Contains(s, secret) {
+		t.Fatalf("APIInfo.String leaks token: %s", s)
+	}
+
+	for _, raw := range []string{
+		secret + ":/ip4/10.0.0.1/tcp/2345/http",
+		secret + ":https://miner.example.com",
+	} {
+		if s := config.RedactAPIInfo(raw); strings.Contains(s, secret) {
+			t.Fatalf("RedactAPIInfo leaks token: %s", s)
+		}
+
+		info := config.APIInfo{Info: raw}
+		if s := info.String(); strings.Contains(s, secret) {
+			t.Fatalf("APIInfo.String leaks token: %s", s)
+		}
+
+		ep, err := info.Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := ep.String(); strings.Contains(s, secret) {
+			t.Fatalf("Endpoint.String leaks token: %s", s)
+		}
+		if s := fmt.Sprint(info); strings.Contains(s, secret) {
+			t.Fatalf("fmt leaks token: %s", s)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	fsKeystore = "keystore"
+	fsAPIInfo  = "apiinfo"
+
+	// KeystorePassEnv keystore的密码，加密和解密都使用这个环境变量
+	KeystorePassEnv = "LOTUS_PILOT_KEYSTORE_PASS"
+)
+
+var keyName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func (r *Repo) keystorePath() string {
+	return filepath.Join(r.path, fsKeystore)
+}
+
+// keystore文件格式：keystoreMagic | salt | nonce | 密文
+var keystoreMagic = []byte("PKS1")
+
+const (
+	keystoreSaltLen = 16
+	//scrypt参数，每次解密约100ms
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// keystoreKey 使用scrypt从密码和salt生成AES-256的key
+func keystoreKey(pass string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(pass), salt, scryptN, scryptR, scryptP, 32)
+}
+
+func keystoreCipher(salt []byte) (cipher.AEAD, error) {
+	pass := os.Getenv(KeystorePassEnv)
+	if pass == "" {
+		return nil, fmt.Errorf("keystore password env %s is empty", KeystorePassEnv)
+	}
+	key, err := keystoreKey(pass, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PutKey 加密保存token到keystore，文件只有owner可以读写
+func (r *Repo) PutKey(name, token string) error {
+	if !keyName.MatchString(name) {
+		return fmt.Errorf("keystore name: %s illegal", name)
+	}
+	if token == "" {
+		return errors.New("token is empty")
+	}
+	salt := make([]byte, keystoreSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := keystoreCipher(salt)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data := append(append(append([]byte{}, keystoreMagic...), salt...), nonce...)
+	data = gcm.Seal(data, nonce, []byte(token), []byte(name))
+
+	err = os.MkdirAll(r.keystorePath(), 0700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.keystorePath(), name), data, 0600)
+}
+
+// GetKey 从keystore读取并解密token
+func (r *Repo) GetKey(name string) (string, error) {
+	if !keyName.MatchString(name) {
+		return "", fmt.Errorf("keystore name: %s illegal", name)
+	}
+	data, err := os.ReadFile(filepath.Join(r.keystorePath(), name))
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(data, keystoreMagic) {
+		return "", fmt.Errorf("keystore: %s unsupported format, put it again", name)
+	}
+	data = data[len(keystoreMagic):]
+	if len(data) < keystoreSaltLen {
+		return "", fmt.Errorf("keystore: %s corrupted", name)
+	}
+	gcm, err := keystoreCipher(data[:keystoreSaltLen])
+	if err != nil {
+		return "", err
+	}
+	data = data[keystoreSaltLen:]
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("keystore: %s corrupted", name)
+	}
+	token, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("keystore: %s decrypt: %w", name, err)
+	}
+	return string(token), nil
+}
+
+func (r *Repo) ListKeys() ([]string, error) {
+	entries, err := os.ReadDir(r.keystorePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			out = append(out, e.Name())
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// ResolveToken 解析token引用：env:NAME 读取环境变量，file:PATH 读取文件，keystore:NAME 读取keystore，
+// 其他值认为是token本身
+func (r *Repo) ResolveToken(ref string) (string, error) {
+	var token string
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		token = os.Getenv(name)
+		if token == "" {
+			return "", fmt.Errorf("token env %s is empty", name)
+		}
+	case strings.HasPrefix(ref, "file:"):
+		data, err := os.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return "", err
+		}
+		token = strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("token %s is empty", ref)
+		}
+	case strings.HasPrefix(ref, "keystore:"):
+		var err error
+		token, err = r.GetKey(strings.TrimPrefix(ref, "keystore:"))
+		if err != nil {
+			return "", err
+		}
+	default:
+		token = ref
+	}
+	return token, nil
+}
+
+// APIInfoFile 本地保存的miner API信息文件
+func APIInfoFile(scriptsPath, miner string) string {
+	return filepath.Join(scriptsPath, fsAPIInfo, miner+".api")
+}
+
+// WriteAPIInfoFile 保存miner API信息，复制到worker机器后脚本从文件读取MINER_API_INFO
+func (r *Repo) WriteAPIInfoFile(miner, apiInfo string) error {
+	err := os.MkdirAll(filepath.Join(r.ScriptsPath(), fsAPIInfo), 0700)
+	if err != nil {
+		return err
+	}
+	name := APIInfoFile(r.ScriptsPath(), miner)
+	err = os.WriteFile(name, []byte(apiInfo+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	//WriteFile不修改已经存在的文件的权限
+	return os.Chmod(name, 0600)
+}
@@ -0,0 +1,142 @@
+package repo
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeystore(t *testing.T) {
+	r, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(KeystorePassEnv, "pass")
+	err = r.PutKey("t017387", "secret-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filepath.Join(r.keystorePath(), "t017387"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("keystore file mode: %s", fi.Mode())
+	}
+
+	token, err := r.ResolveToken("keystore:t017387")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "secret-token" {
+		t.Fatalf("got token: %s", token)
+	}
+
+	names, err := r.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "t017387" {
+		t.Fatalf("got names: %v", names)
+	}
+
+	t.Setenv(KeystorePassEnv, "wrong")
+	_, err = r.GetKey("t017387")
+	if err == nil {
+		t.Fatal("expect decrypt error with wrong password")
+	}
+
+	t.Setenv(KeystorePassEnv, "")
+	_, err = r.GetKey("t017387")
+	if err == nil {
+		t.Fatal("expect error without password")
+	}
+
+	err = r.PutKey("../t017387", "secret-token")
+	if err == nil {
+		t.Fatal("expect error with illegal name")
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	r, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PILOT_TEST_TOKEN", "env-token")
+	token, err := r.ResolveToken("env:PILOT_TEST_TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "env-token" {
+		t.Fatalf("got token: %s", token)
+	}
+
+	_, err = r.ResolveToken("env:PILOT_TEST_TOKEN_EMPTY")
+	if err == nil {
+		t.Fatal("expect error with empty env")
+	}
+
+	name := filepath.Join(t.TempDir(), "token")
+	err = os.WriteFile(name, []byte("file-token\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err = r.ResolveToken("file:" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "file-token" {
+		t.Fatalf("got token: %s", token)
+	}
+
+	token, err = r.ResolveToken("raw-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "raw-token" {
+		t.Fatalf("got token: %s", token)
+	}
+}
+
+func TestKeystoreSalt(t *testing.T) {
+	t.Setenv(KeystorePassEnv, "pass")
+
+	var files [][]byte
+	for i := 0; i < 2; i++ {
+		r, err := New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = r.PutKey("t017387", "secret-token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(r.keystorePath(), "t017387"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, data)
+	}
+
+	if bytes.Equal(files[0], files[1]) {
+		t.Fatal("same password should produce different ciphertext")
+	}
+
+	var keys [][]byte
+	for _, data := range files {
+		salt := data[len(keystoreMagic) : len(keystoreMagic)+keystoreSaltLen]
+		key, err := keystoreKey("pass", salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if bytes.Equal(keys[0], keys[1]) {
+		t.Fatal("same password should produce different keys")
+	}
+}
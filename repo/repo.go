@@ -3,10 +3,16 @@ package repo
 import (
 	"embed"
 	"encoding/json"
+	"fmt"
 	"math/rand"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
@@ -23,6 +29,12 @@ const (
 	fsWorker32G = "worker32G.tmpl"
 	fsWorker64G = "worker64G.tmpl"
 	fsSwitch    = "switch.json"
+	fsHost      = "host.json"
+	fsPin       = "pin.json"
+	fsQuota     = "quota.json"
+	fsHealth    = "health.json"
+	fsDuration  = "duration.json"
+	fsRecords   = "records"
 )
 
 var log = logging.Logger("pilot/repo")
@@ -160,7 +172,9 @@ func (r *Repo) ScriptsPath() string {
 	return filepath.Join(r.path, fsScripts)
 }
 
-func (r *Repo) CreateScript(miner address.Address, token string, size abi.SectorSize) error {
+// CreateScript 生成worker启动脚本，apiInfoDir不为空时脚本从worker机器上apiInfoDir中的文件读取MINER_API_INFO，
+// API信息单独保存在scripts/apiinfo中
+func (r *Repo) CreateScript(miner address.Address, apiInfo string, size abi.SectorSize, apiInfoDir string) error {
 	var t *template.Template
 	var err error
 
@@ -172,9 +186,16 @@ func (r *Repo) CreateScript(miner address.Address, token string, size abi.Sector
 
 	mp := MinerParse{
 		MinerID:      miner.String(),
-		MinerAPIInfo: token,
+		MinerAPIInfo: apiInfo,
 		Port:         port,
 	}
+	if apiInfoDir != "" {
+		err = r.WriteAPIInfoFile(miner.String(), apiInfo)
+		if err != nil {
+			return err
+		}
+		mp.MinerAPIInfo = fmt.Sprintf("$(cat %s)", path.Join(apiInfoDir, miner.String()+".api"))
+	}
 
 	if size == 68719476736 {
 		t, err = template.ParseFiles(r.worker64G())
@@ -200,7 +221,7 @@ func (r *Repo) CreateScript(miner address.Address, token string, size abi.Sector
 		return err
 	}
 
-	log.Infof("create script: %s", name)
+	log.Infow("create script", "name", name, "apiInfo", config.RedactAPIInfo(mp.MinerAPIInfo))
 	return nil
 }
 
@@ -212,6 +233,11 @@ func (r *Repo) RemoveScript(miner string) error {
 	}
 
 	log.Infof("remove script: %s", name)
+
+	err = os.Remove(APIInfoFile(r.ScriptsPath(), miner))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
 
@@ -252,3 +278,188 @@ func (r *Repo) WriteSwitchState(data []byte) error {
 func (r *Repo) ReadSwitchState() ([]byte, error) {
 	return os.ReadFile(r.switchStateFile())
 }
+
+func (r *Repo) hostStateFile() string {
+	return filepath.Join(r.path, fsState, fsHost)
+}
+
+func (r *Repo) WriteHostState(data []byte) error {
+	return os.WriteFile(r.hostStateFile(), data, 0666)
+}
+
+// ReadHostState 旧版本的repo没有host.json，返回空
+func (r *Repo) ReadHostState() ([]byte, error) {
+	data, err := os.ReadFile(r.hostStateFile())
+	if os.IsNotExist(err) {
+		return []byte("{}"), nil
+	}
+	return data, err
+}
+
+func (r *Repo) pinStateFile() string {
+	return filepath.Join(r.path, fsState, fsPin)
+}
+
+func (r *Repo) WritePinState(data []byte) error {
+	return os.WriteFile(r.pinStateFile(), data, 0666)
+}
+
+// ReadPinState 没有pin.json时返回空
+func (r *Repo) ReadPinState() ([]byte, error) {
+	data, err := os.ReadFile(r.pinStateFile())
+	if os.IsNotExist(err) {
+		return []byte("{}"), nil
+	}
+	return data, err
+}
+
+func (r *Repo) quotaStateFile() string {
+	return filepath.Join(r.path, fsState, fsQuota)
+}
+
+func (r *Repo) WriteQuotaState(data []byte) error {
+	return os.WriteFile(r.quotaStateFile(), data, 0666)
+}
+
+// ReadQuotaState 没有quota.json时返回空
+func (r *Repo) ReadQuotaState() ([]byte, error) {
+	data, err := os.ReadFile(r.quotaStateFile())
+	if os.IsNotExist(err) {
+		return []byte("{}"), nil
+	}
+	return data, err
+}
+
+func (r *Repo) durationStateFile() string {
+	return filepath.Join(r.path, fsState, fsDuration)
+}
+
+func (r *Repo) WriteDurationState(data []byte) error {
+	return os.WriteFile(r.durationStateFile(), data, 0666)
+}
+
+// ReadDurationState 没有duration.json时返回空
+func (r *Repo) ReadDurationState() ([]byte, error) {
+	data, err := os.ReadFile(r.durationStateFile())
+	if os.IsNotExist(err) {
+		return []byte("{}"), nil
+	}
+	return data, err
+}
+
+func (r *Repo) healthStateFile() string {
+	return filepath.Join(r.path, fsState, fsHealth)
+}
+
+func (r *Repo) WriteHealthState(data []byte) error {
+	return os.WriteFile(r.healthStateFile(), data, 0666)
+}
+
+// ReadHealthState 没有health.json时返回空
+func (r *Repo) ReadHealthState() ([]byte, error) {
+	data, err := os.ReadFile(r.healthStateFile())
+	if os.IsNotExist(err) {
+		return []byte("{}"), nil
+	}
+	return data, err
+}
+
+func (r *Repo) recordsPath() string {
+	return filepath.Join(r.path, fsRecords)
+}
+
+// WriteRecord 保存miner在t时刻的记录，文件名为unix时间
+func (r *Repo) WriteRecord(miner string, t time.Time, data []byte) error {
+	dir := filepath.Join(r.recordsPath(), miner)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, strconv.FormatInt(t.Unix(), 10)+".json"), data, 0666)
+}
+
+// ReadRecords 按时间顺序读取[since, until)之间所有miner的记录，零值表示不限制
+func (r *Repo) ReadRecords(since, until time.Time) ([][]byte, error) {
+	type record struct {
+		t    int64
+		path string
+	}
+
+	var records []record
+	miners, err := os.ReadDir(r.recordsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range miners {
+		if !m.IsDir() {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(r.recordsPath(), m.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			t, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), ".json"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if !since.IsZero() && t < since.Unix() {
+				continue
+			}
+			if !until.IsZero() && t >= until.Unix() {
+				continue
+			}
+			records = append(records, record{t: t, path: filepath.Join(r.recordsPath(), m.Name(), f.Name())})
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].t < records[j].t
+	})
+
+	var out [][]byte
+	for _, rec := range records {
+		data, err := os.ReadFile(rec.path)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, data)
+	}
+	return out, nil
+}
+
+// PruneRecords 删除before之前的记录
+func (r *Repo) PruneRecords(before time.Time) error {
+	miners, err := os.ReadDir(r.recordsPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, m := range miners {
+		if !m.IsDir() {
+			continue
+		}
+		dir := filepath.Join(r.recordsPath(), m.Name())
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			t, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), ".json"), 10, 64)
+			if err != nil || t >= before.Unix() {
+				continue
+			}
+			err = os.Remove(filepath.Join(dir, f.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}